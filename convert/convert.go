@@ -2,6 +2,7 @@ package convert
 
 import (
 	"errors"
+	"fmt"
 	"main/convert/scribe"
 	"main/utils"
 	"strings"
@@ -11,6 +12,28 @@ func checkSuffixes(inPath, outPath, suffOne, suffTwo string) bool {
 	return strings.HasSuffix(inPath, suffOne) && strings.HasSuffix(outPath, suffTwo)
 }
 
+// Checks each input survives conversion to JSON and back unchanged.
+func RoundTrip(args *utils.Args) error {
+	var failed bool
+	for _, path := range args.InPaths {
+		if !strings.HasSuffix(path, ".scribe_pad") {
+			return errors.New("Round trip only supports scribe files.")
+		}
+		fmt.Println("Checking " + path + "...")
+		err := scribe.RoundTrip(path)
+		if err != nil {
+			fmt.Println(err)
+			failed = true
+		} else {
+			fmt.Println("OK.")
+		}
+	}
+	if failed {
+		return errors.New("Round trip failed.")
+	}
+	return nil
+}
+
 func Run(args *utils.Args) error {
 	var err error
 	inPath := args.InPaths[0]
@@ -20,6 +43,17 @@ func Run(args *utils.Args) error {
 		err = scribe.To(args)
 	case checkSuffixes(inPath, outPath, ".scribe_pad", ".json"):
 		err = scribe.From(args)
+	case checkSuffixes(inPath, outPath, ".scribe_pad", ".csv"),
+		checkSuffixes(inPath, outPath, ".scribe_pad", ".tsv"):
+		err = scribe.ExportCSV(args)
+	case checkSuffixes(inPath, outPath, ".csv", ".scribe_pad"),
+		checkSuffixes(inPath, outPath, ".tsv", ".scribe_pad"):
+		err = scribe.ImportCSV(args)
+	case checkSuffixes(inPath, outPath, ".scribe_pad", ".pot"),
+		checkSuffixes(inPath, outPath, ".scribe_pad", ".po"):
+		err = scribe.ExportPO(args)
+	case checkSuffixes(inPath, outPath, ".po", ".scribe_pad"):
+		err = scribe.ImportPO(args)
 	default:
 		err = errors.New("Invalid input and output file extension combination.")
 	}
@@ -0,0 +1,319 @@
+package scribe
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"main/utils"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Translators only need type_string and text; the columns after them
+// carry the rest of each entry and can be hidden in the spreadsheet.
+// Padding isn't among them, as it's worked out from the text again on
+// Encode.
+var csvColumns = []string{
+	"index", "type_string", "text",
+	"unk", "unk_two", "unk_three", "text_unk",
+}
+
+// Excel only reads CSV as UTF-8 when it starts with a BOM.
+const bom = "\ufeff"
+
+// Tab for .tsv paths, comma otherwise.
+func CSVComma(path string) rune {
+	if strings.HasSuffix(strings.ToLower(path), ".tsv") {
+		return '\t'
+	}
+	return ','
+}
+
+// Separator of a CSV, going by its header line: whichever of comma,
+// semicolon and tab it has most of. Spreadsheets set to a locale with a
+// decimal comma save with semicolons.
+func sniffComma(header string) rune {
+	comma := ','
+	var most int
+	for _, candidate := range []rune{',', ';', '\t'} {
+		count := strings.Count(header, string(candidate))
+		if count > most {
+			comma = candidate
+			most = count
+		}
+	}
+	return comma
+}
+
+// Spreadsheets run cells starting with these as formulas. Such cells
+// are exported behind an apostrophe, which spreadsheets read as "keep
+// as text" and which is stripped again on import. Cells starting with
+// an apostrophe are escaped too, so they survive the trip.
+const escapedChars = "=+-@'"
+
+func escapeCell(value string) string {
+	if value != "" && strings.ContainsRune(escapedChars, rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// Strips the apostrophe escapeCell adds, and no other: one typed in
+// front of anything else, as in "'Til death", is kept.
+func unescapeCell(value string) string {
+	if len(value) > 1 && value[0] == '\'' && strings.ContainsRune(escapedChars, rune(value[1])) {
+		return value[1:]
+	}
+	return value
+}
+
+// EncodeCSV writes a row per entry of scribe, separated by comma.
+// Type strings and text that a spreadsheet would take for a formula are
+// escaped, see escapeCell. scribe is left as it is.
+func EncodeCSV(w io.Writer, scribe *Scribe, comma rune) error {
+	_, err := io.WriteString(w, bom)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	err = cw.Write(csvColumns)
+	if err != nil {
+		return err
+	}
+	for idx, entry := range scribe.Entries {
+		textUnk := int32(defaultTextUnk)
+		if entry.TextUnk != nil {
+			textUnk = *entry.TextUnk
+		}
+		err = cw.Write([]string{
+			strconv.Itoa(idx),
+			escapeCell(entry.TypeString),
+			escapeCell(entry.Text),
+			strconv.Itoa(int(entry.Unk)),
+			utils.B64Encode(entry.UnkTwo),
+			utils.B64Encode(entry.UnkThree),
+			strconv.Itoa(int(textUnk)),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Sets the fields of entry from the round-trip columns of row that
+// aren't blank.
+func applyRow(entry *Entry, row map[string]string, line int) error {
+	parseInt := func(column string, set func(int64)) error {
+		value := row[column]
+		if value == "" {
+			return nil
+		}
+		num, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("Line %d: invalid %s.", line, column)
+		}
+		set(num)
+		return nil
+	}
+	parseBytes := func(column string, size int, set func([]byte)) error {
+		value := row[column]
+		if value == "" {
+			return nil
+		}
+		dec, err := utils.B64Decode(value)
+		if err != nil || len(dec) != size {
+			return fmt.Errorf("Line %d: %s must be %d bytes of base64.", line, column, size)
+		}
+		set(dec)
+		return nil
+	}
+	err := parseInt("unk", func(num int64) {
+		entry.Unk = int32(num)
+	})
+	if err != nil {
+		return err
+	}
+	err = parseBytes("unk_two", 4, func(dec []byte) {
+		entry.UnkTwo = dec
+	})
+	if err != nil {
+		return err
+	}
+	err = parseBytes("unk_three", unkThreeSize, func(dec []byte) {
+		entry.UnkThree = dec
+	})
+	if err != nil {
+		return err
+	}
+	// type_pad and text_pad columns from older exports are ignored;
+	// they'd be stale once the text is edited.
+	return parseInt("text_unk", func(num int64) {
+		textUnk := int32(num)
+		entry.TextUnk = &textUnk
+	})
+}
+
+// ApplyCSV sets the text of scribe's entries from a CSV written by
+// EncodeCSV, matching rows to entries by type string, and by index too
+// for type strings used by more than one entry. The separator is taken
+// from the header line. Columns are found by their header, so they can
+// be moved, and only type_string and text are required.
+func ApplyCSV(r io.Reader, scribe *Scribe) (*ImportReport, error) {
+	br := bufio.NewReader(r)
+	headerLine, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	cr := csv.NewReader(io.MultiReader(strings.NewReader(headerLine), br))
+	cr.Comma = sniffComma(headerLine)
+	// Spreadsheets may drop trailing empty cells.
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV is empty.")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], bom)
+	}
+	columns := map[string]int{}
+	for idx, name := range header {
+		columns[strings.TrimSpace(name)] = idx
+	}
+	_, hasTypeString := columns["type_string"]
+	_, hasText := columns["text"]
+	if !hasTypeString || !hasText {
+		return nil, errors.New("CSV must have type_string and text columns.")
+	}
+	counts := typeStringCounts(scribe.Entries)
+	entries := map[string]int{}
+	for idx, entry := range scribe.Entries {
+		entries[entry.TypeString] = idx
+	}
+	report := &ImportReport{}
+	seen := map[int]bool{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		row := map[string]string{}
+		for name, idx := range columns {
+			if idx < len(record) {
+				row[name] = record[idx]
+			}
+		}
+		typeString := unescapeCell(row["type_string"])
+		if typeString == "" {
+			continue
+		}
+		idx, ok := entries[typeString]
+		if !ok {
+			report.Unknown = append(report.Unknown, typeString)
+			continue
+		}
+		if counts[typeString] > 1 {
+			idx, err = strconv.Atoi(row["index"])
+			if err != nil || idx < 0 || idx >= len(scribe.Entries) || scribe.Entries[idx].TypeString != typeString {
+				return nil, fmt.Errorf(
+					"Line %d: %s is used by more than one entry, so the row needs the index of one of them.",
+					line, typeString)
+			}
+		}
+		if seen[idx] {
+			return nil, fmt.Errorf("Line %d: %s is in the CSV more than once.",
+				line, entryLabel(scribe.Entries, counts, idx))
+		}
+		seen[idx] = true
+		entry := scribe.Entries[idx]
+		entry.Text = unescapeCell(row["text"])
+		err = applyRow(entry, row, line)
+		if err != nil {
+			return nil, err
+		}
+		report.Updated++
+	}
+	for idx := range scribe.Entries {
+		if !seen[idx] {
+			report.Missing = append(report.Missing, entryLabel(scribe.Entries, counts, idx))
+		}
+	}
+	return report, nil
+}
+
+func printReport(report *ImportReport, source string) {
+	for _, typeString := range report.Unknown {
+		fmt.Println("Not in the scribe, skipped: " + typeString)
+	}
+	for _, typeString := range report.Missing {
+		fmt.Println("Not in the " + source + ", left as is: " + typeString)
+	}
+	if report.Fallback > 0 {
+		fmt.Printf("%d fuzzy or untranslated entries use the source text.\n", report.Fallback)
+	}
+	fmt.Printf("Updated %d entries, %d unknown, %d missing.\n",
+		report.Updated, len(report.Unknown), len(report.Missing))
+}
+
+// Scribe to CSV or TSV.
+func ExportCSV(args *utils.Args) error {
+	scribe, err := readScribe(args.InPaths[0])
+	if err != nil {
+		return err
+	}
+	out, err := utils.CreateAtomic(args.OutPath)
+	if err != nil {
+		return err
+	}
+	defer out.Abort()
+	err = EncodeCSV(out, scribe, CSVComma(args.OutPath))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d entries.\n", len(scribe.Entries))
+	return out.Commit()
+}
+
+// CSV or TSV, applied onto the original scribe given as the second
+// input path, to scribe.
+func ImportCSV(args *utils.Args) error {
+	if len(args.InPaths) < 2 {
+		return errors.New("Importing a CSV needs the original scribe as the second input path.")
+	}
+	scribe, err := readScribe(args.InPaths[1])
+	if err != nil {
+		return err
+	}
+	csvFile, err := os.Open(args.InPaths[0])
+	if err != nil {
+		return err
+	}
+	defer csvFile.Close()
+	report, err := ApplyCSV(csvFile, scribe)
+	if err != nil {
+		return err
+	}
+	printReport(report, "CSV")
+	out, err := utils.CreateAtomic(args.OutPath)
+	if err != nil {
+		return err
+	}
+	defer out.Abort()
+	err = Encode(out, scribe)
+	if err != nil {
+		return err
+	}
+	return out.Commit()
+}
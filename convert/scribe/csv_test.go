@@ -0,0 +1,65 @@
+package scribe
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCSVRoundTrip(t *testing.T) {
+	scribe := &Scribe{Entries: []*Entry{
+		{TypeString: "formula", Text: "=SUM(A1)"},
+		{TypeString: "dup", Text: "first"},
+		{TypeString: "dup", Text: "second"},
+		{TypeString: "dash", Text: "- item, with comma"},
+		{TypeString: "quote", Text: "'Til death"},
+	}}
+	for _, comma := range []rune{',', ';', '\t'} {
+		var buf bytes.Buffer
+		err := EncodeCSV(&buf, scribe, comma)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(buf.String(), string(comma)+"=SUM") {
+			t.Errorf("formula exported unescaped: %q", buf.String())
+		}
+		edited := strings.Replace(buf.String(), "second", "zweite", 1)
+		target := &Scribe{Entries: []*Entry{
+			{TypeString: "formula"}, {TypeString: "dup"}, {TypeString: "dup"}, {TypeString: "dash"},
+			{TypeString: "quote"},
+		}}
+		report, err := ApplyCSV(strings.NewReader(edited), target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Updated != 5 || len(report.Missing) != 0 || len(report.Unknown) != 0 {
+			t.Errorf("%q: report %+v", comma, report)
+		}
+		for idx, want := range []string{"=SUM(A1)", "first", "zweite", "- item, with comma", "'Til death"} {
+			if target.Entries[idx].Text != want {
+				t.Errorf("%q: entry %d text %q, want %q", comma, idx, target.Entries[idx].Text, want)
+			}
+		}
+	}
+}
+
+// Only the apostrophe export adds is removed from cells typed in a
+// spreadsheet.
+func TestCSVKeepsTypedApostrophe(t *testing.T) {
+	scribe := &Scribe{Entries: []*Entry{{TypeString: "a"}, {TypeString: "b"}}}
+	_, err := ApplyCSV(strings.NewReader("type_string,text\na,'Til death\nb,'=1+1\n"), scribe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scribe.Entries[0].Text != "'Til death" || scribe.Entries[1].Text != "=1+1" {
+		t.Errorf("got %q and %q, want 'Til death and =1+1", scribe.Entries[0].Text, scribe.Entries[1].Text)
+	}
+}
+
+func TestCSVDuplicateNeedsIndex(t *testing.T) {
+	scribe := &Scribe{Entries: []*Entry{{TypeString: "dup"}, {TypeString: "dup"}}}
+	_, err := ApplyCSV(strings.NewReader("type_string,text\ndup,a\n"), scribe)
+	if err == nil {
+		t.Error("row for a shared type string without an index was accepted")
+	}
+}
@@ -0,0 +1,246 @@
+package scribe
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"sort"
+	"strings"
+)
+
+// New entries are the ones without unk_two; they only need a type
+// string and text. Everything else is worked out from the existing
+// entries, which is all there is to go on while the fields are unknown.
+
+func isNew(entry *Entry) bool {
+	return len(entry.UnkTwo) == 0
+}
+
+// Hash functions tried against type strings for new entries' IDs.
+var hashes = map[string]func(string) uint32{
+	"crc32": func(s string) uint32 {
+		return crc32.ChecksumIEEE([]byte(s))
+	},
+	"crc32_lower": func(s string) uint32 {
+		return crc32.ChecksumIEEE([]byte(strings.ToLower(s)))
+	},
+	"fnv1a": func(s string) uint32 {
+		hash := fnv.New32a()
+		hash.Write([]byte(s))
+		return hash.Sum32()
+	},
+}
+
+// Hash function every existing ID is the type string hash of, if any.
+func idHash(entries []*Entry) func(string) uint32 {
+	if len(entries) == 0 {
+		return nil
+	}
+	var names []string
+	for name := range hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hash := hashes[name]
+		matched := true
+		for _, entry := range entries {
+			if uint32(entry.Unk) != hash(entry.TypeString) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return hash
+		}
+	}
+	return nil
+}
+
+// How many entries use each type string.
+func typeStringCounts(entries []*Entry) map[string]int {
+	counts := map[string]int{}
+	for _, entry := range entries {
+		counts[entry.TypeString]++
+	}
+	return counts
+}
+
+// Names entry idx in reports: its type string, with the index if other
+// entries use the same one.
+func entryLabel(entries []*Entry, counts map[string]int, idx int) string {
+	typeString := entries[idx].TypeString
+	if counts[typeString] > 1 {
+		return fmt.Sprintf("%s (index %d)", typeString, idx)
+	}
+	return typeString
+}
+
+// Most common value of field across entries, or fallback if there are
+// none.
+func mostCommon(entries []*Entry, field func(*Entry) []byte, fallback []byte) []byte {
+	counts := map[string]int{}
+	best := fallback
+	var bestCount int
+	for _, entry := range entries {
+		value := field(entry)
+		counts[string(value)]++
+		if counts[string(value)] > bestCount {
+			best = value
+			bestCount = counts[string(value)]
+		}
+	}
+	return append([]byte{}, best...)
+}
+
+// Offset in entry just past its type string's terminator.
+func typeEnd(entry *Entry) int {
+	return 28 + len(entry.TypeString) + 1
+}
+
+// Offset in entry just past its text.
+func textEnd(entry *Entry) int {
+	return typeEnd(entry) + *entry.TypePad + 8 + len(entry.Text)
+}
+
+// Works out the padding rule from the pads of entries, for JSON from
+// before the rule was kept. Returns nil if no candidate fits them all.
+func ruleFromPads(entries []*Entry) *PadRule {
+	find := func(at func(*Entry) int, pad func(*Entry) int) ([2]int, bool) {
+		for _, candidate := range padCandidates() {
+			matched := len(entries) > 0
+			for _, entry := range entries {
+				if padTo(candidate[0], candidate[1], at(entry)) != pad(entry) {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				return candidate, true
+			}
+		}
+		return [2]int{}, false
+	}
+	typeRule, ok := find(typeEnd, func(entry *Entry) int {
+		return *entry.TypePad
+	})
+	if !ok {
+		return nil
+	}
+	textRule, ok := find(textEnd, func(entry *Entry) int {
+		return *entry.TextPad
+	})
+	if !ok {
+		return nil
+	}
+	return &PadRule{
+		TypeAlign: typeRule[0],
+		TypeMin:   typeRule[1],
+		TextAlign: textRule[0],
+		TextMin:   textRule[1],
+	}
+}
+
+// Most common of pad across entries, or fallback if there are none.
+func commonPad(entries []*Entry, pad func(*Entry) int, fallback int) int {
+	counts := map[int]int{}
+	common := fallback
+	for _, entry := range entries {
+		counts[pad(entry)]++
+		if counts[pad(entry)] > counts[common] {
+			common = pad(entry)
+		}
+	}
+	return common
+}
+
+// Fills in the fields of new entries, and checks their type strings
+// aren't already used. Returns how many were new.
+func fillNewEntries(scribe *Scribe) (int, error) {
+	var (
+		existing []*Entry
+		added    []*Entry
+		maxUnk   int32
+	)
+	seen := map[string]bool{}
+	for _, entry := range scribe.Entries {
+		if isNew(entry) {
+			added = append(added, entry)
+			continue
+		}
+		seen[entry.TypeString] = true
+		existing = append(existing, entry)
+		if entry.Unk > maxUnk {
+			maxUnk = entry.Unk
+		}
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+	for _, entry := range added {
+		if seen[entry.TypeString] {
+			return 0, errors.New("New entry's type string is already used: " + entry.TypeString)
+		}
+		seen[entry.TypeString] = true
+	}
+	for _, entry := range existing {
+		setDefaults(entry)
+	}
+	hash := idHash(existing)
+	unkTwo := mostCommon(existing, func(entry *Entry) []byte {
+		return entry.UnkTwo
+	}, make([]byte, 4))
+	unkThree := mostCommon(existing, func(entry *Entry) []byte {
+		return entry.UnkThree
+	}, make([]byte, unkThreeSize))
+	textUnks := map[int32]int{}
+	commonTextUnk := int32(defaultTextUnk)
+	for _, entry := range existing {
+		textUnks[*entry.TextUnk]++
+		if textUnks[*entry.TextUnk] > textUnks[commonTextUnk] {
+			commonTextUnk = *entry.TextUnk
+		}
+	}
+	rule := scribe.PadRule
+	if rule == nil {
+		rule = ruleFromPads(existing)
+	}
+	typePad := commonPad(existing, func(entry *Entry) int {
+		return *entry.TypePad
+	}, defaultTypePad)
+	textPad := commonPad(existing, func(entry *Entry) int {
+		return *entry.TextPad
+	}, defaultTextPad)
+	for _, entry := range added {
+		if hash != nil {
+			entry.Unk = int32(hash(entry.TypeString))
+		} else {
+			maxUnk++
+			entry.Unk = maxUnk
+		}
+		entry.UnkTwo = append([]byte{}, unkTwo...)
+		if len(entry.UnkThree) == 0 {
+			entry.UnkThree = append([]byte{}, unkThree...)
+		}
+		if entry.TypePad == nil {
+			pad := typePad
+			if rule != nil {
+				pad = padTo(rule.TypeAlign, rule.TypeMin, typeEnd(entry))
+			}
+			entry.TypePad = &pad
+		}
+		if entry.TextUnk == nil {
+			value := commonTextUnk
+			entry.TextUnk = &value
+		}
+		if entry.TextPad == nil {
+			pad := textPad
+			if rule != nil {
+				pad = padTo(rule.TextAlign, rule.TextMin, textEnd(entry))
+			}
+			entry.TextPad = &pad
+		}
+	}
+	return len(added), nil
+}
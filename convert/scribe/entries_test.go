@@ -0,0 +1,75 @@
+package scribe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+// Decodes a scribe with entries, removes one and adds two, and checks
+// the new entries are filled in from the existing ones and the index
+// is rebuilt over the entries as written.
+func TestAddAndRemoveEntries(t *testing.T) {
+	scribe := newScribe()
+	scribe.Entries = append(scribe.Entries, &Entry{TypeString: "ui_cancel", Text: "Cancel"})
+	common := []byte{1, 2, 3, 4}
+	commonThree := bytes.Repeat([]byte{9}, unkThreeSize)
+	for idx, entry := range scribe.Entries {
+		entry.Unk = int32(crc32.ChecksumIEEE([]byte(entry.TypeString)))
+		entry.UnkTwo = common
+		entry.UnkThree = commonThree
+		if idx == 0 {
+			entry.UnkTwo = []byte{5, 6, 7, 8}
+			entry.UnkThree = make([]byte, unkThreeSize)
+		}
+	}
+	decoded, _ := encodeDecode(t, scribe)
+
+	dup := copyScribe(decoded)
+	dup.Entries = append(dup.Entries, &Entry{TypeString: "ui_ok", Text: "Again"})
+	if err := Encode(&bytes.Buffer{}, dup); err == nil {
+		t.Errorf("new entry reusing a type string wasn't refused")
+	}
+
+	decoded.Entries = append(decoded.Entries[:1], decoded.Entries[2:]...)
+	decoded.Entries = append(decoded.Entries,
+		&Entry{TypeString: "new_one", Text: "First"},
+		&Entry{TypeString: "new_two", Text: "Second, a little longer"})
+	edited, data := encodeDecode(t, decoded)
+	if len(edited.Entries) != 5 || edited.Index == nil {
+		t.Fatalf("got %d entries, index %v; want 5 and an index", len(edited.Entries), edited.Index)
+	}
+	if edited.Entries[1].TypeString != "x" {
+		t.Errorf("entry 1 is %s, want x after removing ui_ok", edited.Entries[1].TypeString)
+	}
+	for _, entry := range edited.Entries[3:] {
+		if uint32(entry.Unk) != crc32.ChecksumIEEE([]byte(entry.TypeString)) {
+			t.Errorf("%s: unk 0x%X isn't the crc32 of its type string", entry.TypeString, entry.Unk)
+		}
+		if !bytes.Equal(entry.UnkTwo, common) || !bytes.Equal(entry.UnkThree, commonThree) {
+			t.Errorf("%s: unk_two %v, unk_three %v aren't the most common ones",
+				entry.TypeString, entry.UnkTwo, entry.UnkThree)
+		}
+	}
+
+	// Walk the entries by their size fields and check the index points
+	// at each of them, in order.
+	pos := entriesOffset
+	end := entriesOffset - 8 + int(binary.LittleEndian.Uint32(data[startOffset+8:]))
+	index := data[end:]
+	if count := binary.LittleEndian.Uint32(index); count != 5 || len(index) != 4+5*8 {
+		t.Fatalf("index has count %d and %d bytes, want 5 and %d", count, len(index), 4+5*8)
+	}
+	for idx, entry := range edited.Entries {
+		unk := int32(binary.LittleEndian.Uint32(index[4+idx*8:]))
+		offset := int(binary.LittleEndian.Uint32(index[8+idx*8:]))
+		if unk != entry.Unk || offset != pos {
+			t.Errorf("index entry %d = unk 0x%X at 0x%X, want 0x%X at 0x%X", idx, unk, offset, entry.Unk, pos)
+		}
+		pos += int(binary.LittleEndian.Uint32(data[pos:]))
+	}
+	if pos != end {
+		t.Errorf("entries end at 0x%X, index starts at 0x%X", pos, end)
+	}
+}
@@ -0,0 +1,132 @@
+package scribe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// The header between the magic and the entries is mostly unknown. What
+// is known is the layout worked out from the entries. A header field
+// holding one of those values in one file may well be a coincidence, so
+// matches are only reported as candidates. A field is only written from
+// the entries on Encode once it's confirmed: listed in HeaderFields by
+// the user, or found at the same offset in sample files with different
+// values. Everything else is kept as raw bytes.
+
+// Layout values below this aren't looked for in the header, as small
+// numbers turn up there for all sorts of reasons.
+const minHeaderValue = 256
+
+// Layout values by the names used in HeaderFields.
+func layoutValues(layout *Layout) map[string]int32 {
+	return map[string]int32{
+		"entry_count":  layout.EntryCount,
+		"entries_size": layout.EntriesSize,
+		"end_offset":   layout.EndOffset,
+		"end_size":     layout.EndSize,
+		"file_size":    layout.FileSize,
+	}
+}
+
+// Works out the layout from the entry sizes and the data after them.
+func setLayout(scribe *Scribe) {
+	endOffset := int32(startOffset+8) + scribe.EntriesHeader.EntriesSize
+	scribe.Layout = Layout{
+		EntryCount:  int32(len(scribe.Entries)),
+		EntriesSize: scribe.EntriesHeader.EntriesSize,
+		EndOffset:   endOffset,
+		EndSize:     int32(len(scribe.EndData)),
+		FileSize:    endOffset + int32(len(scribe.EndData)),
+	}
+}
+
+// Finds the header fields that may hold a layout value: a little endian
+// int32 at a 4 byte aligned offset. A value is only taken if it's there
+// exactly once, and values below minHeaderValue aren't looked for.
+// Offsets are from the start of the file.
+func findHeaderCandidates(header []byte, layout *Layout) map[string]int {
+	found := map[string]int{}
+	for name, value := range layoutValues(layout) {
+		if value < minHeaderValue {
+			continue
+		}
+		var offsets []int
+		for pos := 0; pos+4 <= len(header); pos += 4 {
+			if int32(binary.LittleEndian.Uint32(header[pos:])) == value {
+				offsets = append(offsets, pos+4)
+			}
+		}
+		if len(offsets) == 1 {
+			found[name] = offsets[0]
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	return found
+}
+
+// Confirms the candidates of scribe that every sample has at the same
+// offset, where the value isn't the same in all of them. Those are
+// fields that follow the layout rather than happen to match it once.
+func confirmHeaderFields(scribe *Scribe, samples []*Scribe) map[string]int {
+	if len(samples) == 0 {
+		return nil
+	}
+	confirmed := map[string]int{}
+	for name, offset := range scribe.HeaderCandidates {
+		value := layoutValues(&scribe.Layout)[name]
+		agree, differ := true, false
+		for _, sample := range samples {
+			if sample.HeaderCandidates[name] != offset {
+				agree = false
+				break
+			}
+			if layoutValues(&sample.Layout)[name] != value {
+				differ = true
+			}
+		}
+		if agree && differ {
+			confirmed[name] = offset
+		}
+	}
+	if len(confirmed) == 0 {
+		return nil
+	}
+	return confirmed
+}
+
+// Writes the layout values into the named fields of header, a copy of
+// the scribe's.
+func writeHeaderFields(header []byte, fields map[string]int, layout *Layout) error {
+	values := layoutValues(layout)
+	for name, offset := range fields {
+		value, ok := values[name]
+		if !ok {
+			return fmt.Errorf("Unknown header field: %s.", name)
+		}
+		pos := offset - 4
+		if pos < 0 || pos%4 != 0 || pos+4 > len(header) {
+			return fmt.Errorf("Header field %s offset 0x%X isn't an aligned offset in the header.", name, offset)
+		}
+		binary.LittleEndian.PutUint32(header[pos:], uint32(value))
+	}
+	return nil
+}
+
+// Describes the named header fields, by offset.
+func headerFieldNames(fields map[string]int) []string {
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return fields[names[i]] < fields[names[j]]
+	})
+	var lines []string
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%s at 0x%X", name, fields[name]))
+	}
+	return lines
+}
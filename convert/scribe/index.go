@@ -0,0 +1,63 @@
+package scribe
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// The data after the entries is an index over them: an int32 count,
+// then per entry, in entry order, its unk and its offset from the start
+// of the file as int32s. Data that isn't exactly that is kept as it is.
+
+// Where the first entry starts.
+const entriesOffset = startOffset + 16
+
+var indexFields = []string{"unk", "offset"}
+
+func entryOffsets(scribe *Scribe) []int64 {
+	var offsets []int64
+	pos := int64(entriesOffset)
+	for _, entry := range scribe.Entries {
+		offsets = append(offsets, pos)
+		pos += int64(entry.Size)
+	}
+	return offsets
+}
+
+func checkIndex(index *Index) error {
+	if strings.Join(index.Fields, ",") != strings.Join(indexFields, ",") {
+		return errors.New("Index fields must be " + strings.Join(indexFields, ", ") +
+			", got " + strings.Join(index.Fields, ", ") + ".")
+	}
+	return nil
+}
+
+// Builds the index from the entries, which must have their sizes set.
+func encodeIndex(scribe *Scribe, index *Index) ([]byte, error) {
+	err := checkIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4+len(scribe.Entries)*8)
+	binary.LittleEndian.PutUint32(buf, uint32(len(scribe.Entries)))
+	for idx, offset := range entryOffsets(scribe) {
+		binary.LittleEndian.PutUint32(buf[4+idx*8:], uint32(scribe.Entries[idx].Unk))
+		binary.LittleEndian.PutUint32(buf[8+idx*8:], uint32(offset))
+	}
+	return buf, nil
+}
+
+// Reads the data after the entries as the index. Returns nil if it
+// isn't one, byte for byte.
+func decodeIndex(scribe *Scribe) *Index {
+	if len(scribe.Entries) == 0 {
+		return nil
+	}
+	index := &Index{Fields: indexFields}
+	data, err := encodeIndex(scribe, index)
+	if err != nil || string(data) != string(scribe.EndData) {
+		return nil
+	}
+	return index
+}
@@ -0,0 +1,86 @@
+package scribe
+
+import (
+	"encoding/json"
+	"io"
+	"main/utils"
+)
+
+// DecodeJSON parses the JSON form of a scribe. Entries without unk_two
+// are new; Encode fills them in.
+func DecodeJSON(r io.Reader) (*Scribe, error) {
+	var scribe Scribe
+	err := json.NewDecoder(r).Decode(&scribe)
+	if err != nil {
+		return nil, err
+	}
+	if scribe.EntriesHeader == nil {
+		scribe.EntriesHeader = &EntriesHeader{}
+	}
+	scribe.Header, err = utils.B64Decode(scribe.HeaderB64)
+	if err != nil {
+		return nil, err
+	}
+	scribe.EntriesHeader.Unk, err = utils.B64Decode(scribe.EntriesHeader.UnkB64)
+	if err != nil {
+		return nil, err
+	}
+	scribe.EndData, err = utils.B64Decode(scribe.EndDataB64)
+	if err != nil {
+		return nil, err
+	}
+	scribe.HeaderB64 = ""
+	scribe.EntriesHeader.UnkB64 = ""
+	scribe.EndDataB64 = ""
+	for _, entry := range scribe.Entries {
+		entry.UnkTwo, err = utils.B64Decode(entry.UnkTwoB64)
+		if err != nil {
+			return nil, err
+		}
+		entry.UnkThree, err = utils.B64Decode(entry.UnkThreeB64)
+		if err != nil {
+			return nil, err
+		}
+		entry.UnkTwoB64 = ""
+		entry.UnkThreeB64 = ""
+	}
+	return &scribe, nil
+}
+
+// EncodeJSON writes the JSON form of scribe. Sizes and lengths are left
+// out as they're worked out again on Encode, and so are the pads when
+// there's a padding rule.
+func EncodeJSON(w io.Writer, scribe *Scribe) error {
+	outScribe := *scribe
+	entriesHeader := *scribe.EntriesHeader
+	entriesHeader.UnkB64 = utils.B64Encode(entriesHeader.Unk)
+	entriesHeader.Unk = nil
+	entriesHeader.EntriesSize = 0
+	outScribe.EntriesHeader = &entriesHeader
+	outScribe.HeaderB64 = utils.B64Encode(outScribe.Header)
+	outScribe.Header = nil
+	outScribe.EndDataB64 = utils.B64Encode(outScribe.EndData)
+	outScribe.EndData = nil
+	outScribe.Entries = make([]*Entry, len(scribe.Entries))
+	for idx, entry := range scribe.Entries {
+		outEntry := *entry
+		outEntry.UnkTwoB64 = utils.B64Encode(entry.UnkTwo)
+		outEntry.UnkTwo = nil
+		outEntry.UnkThreeB64 = utils.B64Encode(entry.UnkThree)
+		outEntry.UnkThree = nil
+		outEntry.Size = 0
+		outEntry.TypeStringLen = 0
+		outEntry.TextLen = 0
+		if scribe.PadRule != nil {
+			outEntry.TypePad = nil
+			outEntry.TextPad = nil
+		}
+		outScribe.Entries[idx] = &outEntry
+	}
+	m, err := json.MarshalIndent(&outScribe, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(m)
+	return err
+}
@@ -0,0 +1,363 @@
+package scribe
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"main/utils"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var poEscaper = strings.NewReplacer(
+	"\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\r", "\\r", "\t", "\\t")
+
+// Writes keyword and value as a PO string, split after each newline
+// as gettext does for multiline strings.
+func writePOString(b *strings.Builder, keyword, value string) {
+	lines := strings.SplitAfter(value, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) < 2 {
+		b.WriteString(keyword + " \"" + poEscaper.Replace(value) + "\"\n")
+		return
+	}
+	b.WriteString(keyword + " \"\"\n")
+	for _, line := range lines {
+		b.WriteString("\"" + poEscaper.Replace(line) + "\"\n")
+	}
+}
+
+// Language of a path named like activity.fr.scribe_pad, or empty if it
+// has none.
+func LangFromPath(path string) string {
+	name := filepath.Base(path)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	idx := strings.LastIndex(name, ".")
+	if idx == -1 {
+		return ""
+	}
+	return name[idx+1:]
+}
+
+// msgctxt of entry idx: its type string, with #index after it if other
+// entries use the same one.
+func poContext(entries []*Entry, counts map[string]int, idx int) string {
+	typeString := entries[idx].TypeString
+	if counts[typeString] > 1 {
+		return typeString + "#" + strconv.Itoa(idx)
+	}
+	return typeString
+}
+
+// Index of the entry a msgctxt written by poContext is for, or -1.
+func poEntryIndex(entries []*Entry, counts map[string]int, byType map[string]int, context string) int {
+	if idx, ok := byType[context]; ok && counts[context] == 1 {
+		return idx
+	}
+	hash := strings.LastIndex(context, "#")
+	if hash == -1 {
+		return -1
+	}
+	idx, err := strconv.Atoi(context[hash+1:])
+	if err != nil || idx < 0 || idx >= len(entries) || entries[idx].TypeString != context[:hash] {
+		return -1
+	}
+	return idx
+}
+
+// EncodePO writes a message per entry of source, with the type string
+// as msgctxt and the text as msgid. Type strings used by more than one
+// entry get the entry's index after them, see poContext. msgstr is the
+// text of the matching entry in target, or empty if there's none. With
+// a nil target, this is a POT template.
+func EncodePO(w io.Writer, source, target *Scribe, lang string) error {
+	counts := typeStringCounts(source.Entries)
+	translations := map[string]string{}
+	if target != nil {
+		targetCounts := typeStringCounts(target.Entries)
+		for idx := range target.Entries {
+			translations[poContext(target.Entries, targetCounts, idx)] = target.Entries[idx].Text
+		}
+	}
+	var b strings.Builder
+	b.WriteString("msgid \"\"\nmsgstr \"\"\n")
+	b.WriteString("\"Language: " + poEscaper.Replace(lang) + "\\n\"\n")
+	b.WriteString("\"MIME-Version: 1.0\\n\"\n")
+	b.WriteString("\"Content-Type: text/plain; charset=UTF-8\\n\"\n")
+	b.WriteString("\"Content-Transfer-Encoding: 8bit\\n\"\n")
+	b.WriteString("\"X-Generator: SRTools\\n\"\n")
+	for idx, entry := range source.Entries {
+		context := poContext(source.Entries, counts, idx)
+		b.WriteString("\n")
+		writePOString(&b, "msgctxt", context)
+		writePOString(&b, "msgid", entry.Text)
+		writePOString(&b, "msgstr", translations[context])
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Value of field in the header of a PO, from its header message.
+func poHeaderField(header, field string) string {
+	for _, line := range strings.Split(header, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), field) {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// Whether PO language codes a and b are the same language, taking a
+// code without a region to match any region of it: fr matches fr_FR.
+func sameLang(a, b string) bool {
+	a = strings.ToLower(strings.ReplaceAll(a, "-", "_"))
+	b = strings.ToLower(strings.ReplaceAll(b, "-", "_"))
+	if a == b {
+		return true
+	}
+	if !strings.Contains(a, "_") || !strings.Contains(b, "_") {
+		aLang, _, _ := strings.Cut(a, "_")
+		bLang, _, _ := strings.Cut(b, "_")
+		return aLang == bLang
+	}
+	return false
+}
+
+// Reverses poEscaper on a quoted PO string.
+func unquotePO(s string) (string, bool) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", false
+	}
+	s = s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", false
+		}
+		switch s[i] {
+		case '\\', '"':
+			b.WriteByte(s[i])
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		default:
+			return "", false
+		}
+	}
+	return b.String(), true
+}
+
+// Reads the messages of a PO file. Obsolete messages are skipped and
+// only the first form of plurals is kept.
+func parsePO(r io.Reader) ([]*poEntry, error) {
+	var (
+		entries []*poEntry
+		field   *string
+		// Set once the current message has a msgstr, so the next
+		// comment or keyword starts a new one.
+		hasStr bool
+		unused string
+	)
+	cur := &poEntry{}
+	started := false
+	next := func() {
+		if started {
+			entries = append(entries, cur)
+		}
+		cur = &poEntry{}
+		started = false
+		hasStr = false
+		field = nil
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if lineNum == 1 {
+			line = strings.TrimPrefix(line, bom)
+		}
+		keyword, value, _ := strings.Cut(line, " ")
+		if hasStr && (strings.HasPrefix(line, "#") || keyword == "msgctxt" || keyword == "msgid") {
+			next()
+		}
+		switch {
+		case line == "" || strings.HasPrefix(line, "#~"):
+			continue
+		case strings.HasPrefix(line, "#,"):
+			for _, flag := range strings.Split(line[2:], ",") {
+				if strings.TrimSpace(flag) == "fuzzy" {
+					cur.Fuzzy = true
+				}
+			}
+			continue
+		case strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "\""):
+			if field == nil {
+				return nil, fmt.Errorf("Line %d: string without a keyword.", lineNum)
+			}
+			value = line
+		case keyword == "msgctxt":
+			cur.HasContext = true
+			field = &cur.Context
+		case keyword == "msgid":
+			field = &cur.ID
+		case keyword == "msgstr" || keyword == "msgstr[0]":
+			field = &cur.Str
+			hasStr = true
+		case keyword == "msgid_plural" || strings.HasPrefix(keyword, "msgstr["):
+			field = &unused
+		default:
+			return nil, fmt.Errorf("Line %d: unknown PO keyword.", lineNum)
+		}
+		str, ok := unquotePO(strings.TrimSpace(value))
+		if !ok {
+			return nil, fmt.Errorf("Line %d: invalid PO string.", lineNum)
+		}
+		*field += str
+		started = true
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	next()
+	return entries, nil
+}
+
+// ApplyPO sets the text of scribe's entries from a PO file, matching
+// messages to entries by msgctxt. Fuzzy or untranslated messages give
+// the source text, their msgid. If lang isn't empty, the PO's Language
+// header, if it has one, must be the same language.
+func ApplyPO(r io.Reader, scribe *Scribe, lang string) (*ImportReport, error) {
+	messages, err := parsePO(r)
+	if err != nil {
+		return nil, err
+	}
+	counts := typeStringCounts(scribe.Entries)
+	byType := map[string]int{}
+	for idx, entry := range scribe.Entries {
+		byType[entry.TypeString] = idx
+	}
+	report := &ImportReport{}
+	seen := map[int]bool{}
+	for _, message := range messages {
+		if !message.HasContext {
+			if message.ID != "" {
+				return nil, errors.New("PO message has no msgctxt: " + message.ID)
+			}
+			// The header.
+			poLang := poHeaderField(message.Str, "Language")
+			if lang != "" && poLang != "" && !sameLang(poLang, lang) {
+				return nil, fmt.Errorf("PO is for language %s, but the scribe is for %s.", poLang, lang)
+			}
+			continue
+		}
+		idx := poEntryIndex(scribe.Entries, counts, byType, message.Context)
+		if idx == -1 {
+			report.Unknown = append(report.Unknown, message.Context)
+			continue
+		}
+		if seen[idx] {
+			return nil, errors.New("Type string is in the PO more than once: " + message.Context)
+		}
+		seen[idx] = true
+		text := message.Str
+		if message.Fuzzy || text == "" {
+			text = message.ID
+			if message.ID != "" {
+				report.Fallback++
+			}
+		}
+		scribe.Entries[idx].Text = text
+		report.Updated++
+	}
+	for idx := range scribe.Entries {
+		if !seen[idx] {
+			report.Missing = append(report.Missing, entryLabel(scribe.Entries, counts, idx))
+		}
+	}
+	return report, nil
+}
+
+// Scribe to POT, or source and target scribes to PO.
+func ExportPO(args *utils.Args) error {
+	source, err := readScribe(args.InPaths[0])
+	if err != nil {
+		return err
+	}
+	var (
+		target *Scribe
+		lang   string
+	)
+	if strings.HasSuffix(args.OutPath, ".po") {
+		if len(args.InPaths) < 2 {
+			return errors.New("A PO needs the source scribe, then the target scribe, as input paths.")
+		}
+		target, err = readScribe(args.InPaths[1])
+		if err != nil {
+			return err
+		}
+		lang = LangFromPath(args.InPaths[1])
+	}
+	out, err := utils.CreateAtomic(args.OutPath)
+	if err != nil {
+		return err
+	}
+	defer out.Abort()
+	err = EncodePO(out, source, target, lang)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d entries.\n", len(source.Entries))
+	return out.Commit()
+}
+
+// PO, applied onto the scribe given as the second input path, to
+// scribe. A PO for another language than the output's name gives is
+// refused.
+func ImportPO(args *utils.Args) error {
+	if len(args.InPaths) < 2 {
+		return errors.New("Importing a PO needs a scribe to apply it onto as the second input path.")
+	}
+	scribe, err := readScribe(args.InPaths[1])
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(args.InPaths[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	report, err := ApplyPO(f, scribe, LangFromPath(args.OutPath))
+	if err != nil {
+		return err
+	}
+	printReport(report, "PO")
+	out, err := utils.CreateAtomic(args.OutPath)
+	if err != nil {
+		return err
+	}
+	defer out.Abort()
+	err = Encode(out, scribe)
+	if err != nil {
+		return err
+	}
+	return out.Commit()
+}
@@ -0,0 +1,43 @@
+package scribe
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPOSharedTypeStrings(t *testing.T) {
+	source := &Scribe{Entries: []*Entry{
+		{TypeString: "ok", Text: "OK"},
+		{TypeString: "dup", Text: "first"},
+		{TypeString: "dup", Text: "second"},
+	}}
+	var buf bytes.Buffer
+	err := EncodePO(&buf, source, source, "fr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, context := range []string{`msgctxt "ok"`, `msgctxt "dup#1"`, `msgctxt "dup#2"`} {
+		if !strings.Contains(buf.String(), context) {
+			t.Errorf("no %s in\n%s", context, buf.String())
+		}
+	}
+	po := strings.Replace(buf.String(), `msgstr "second"`, `msgstr "zweite"`, 1)
+	target := &Scribe{Entries: []*Entry{{TypeString: "ok"}, {TypeString: "dup"}, {TypeString: "dup"}}}
+	report, err := ApplyPO(strings.NewReader(po), target, "fr_FR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Updated != 3 || len(report.Missing) != 0 || len(report.Unknown) != 0 {
+		t.Errorf("report %+v", report)
+	}
+	for idx, want := range []string{"OK", "first", "zweite"} {
+		if target.Entries[idx].Text != want {
+			t.Errorf("entry %d text %q, want %q", idx, target.Entries[idx].Text, want)
+		}
+	}
+	_, err = ApplyPO(strings.NewReader(po), target, "de")
+	if err == nil {
+		t.Error("PO for fr was applied to a de scribe")
+	}
+}
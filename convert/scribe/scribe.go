@@ -1,66 +1,171 @@
 package scribe
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/json"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"main/utils"
 	"os"
 	"strconv"
+	"strings"
 )
 
-const startOffset = 0x140
+const (
+	startOffset = 0x140
+	headerSize  = startOffset - 4
+	// Fixed fields of an entry plus a type string of just the null
+	// terminator.
+	minEntrySize = 37
+	// Largest minimum padding in a PadRule, and the most padding one
+	// can give.
+	maxMinPad = 8
+	maxPad    = maxMinPad + 7
+	// What was always written before the per-entry fields were kept,
+	// used for JSON without them.
+	defaultTypePad = 2
+	defaultTextUnk = 8192
+	defaultTextPad = 2
+	unkThreeSize   = 12
+)
 
 var magic = [4]byte{0x54, 0x38, 0x09, 0x00}
 
-func checkMagic(f *os.File) (bool, error) {
+func checkMagic(r io.Reader) (bool, error) {
 	buf := make([]byte, 4)
-	_, err := f.Read(buf)
+	_, err := io.ReadFull(r, buf)
 	if err != nil {
 		return false, err
 	}
 	return bytes.Equal(buf, magic[:]), nil
 }
 
-func readString(f *os.File, length int32, isType bool) (string, error) {
-	buf := make([]byte, length)
-	_, err := f.Read(buf)
-	if err != nil {
-		return "", err
+func entryError(idx int, offset int64, msg string) error {
+	return fmt.Errorf("Entry %d at 0x%X: %s", idx, offset, msg)
+}
+
+func isZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0x0 {
+			return false
+		}
 	}
-	if isType {
-		return string(buf[:len(buf)-1]), nil
+	return true
+}
+
+// Null bytes needed after at bytes of an entry: at least min, and
+// enough to end on a multiple of align.
+func padTo(align, min, at int) int {
+	pad := min
+	for (at+pad)%align != 0 {
+		pad++
+	}
+	return pad
+}
+
+// Pads tried, in order, when working out the padding rule of a scribe.
+// Larger alignments go first, so a rule that merely happens to fit
+// isn't picked over a stricter one. Several minimums can fit the same
+// entries: with an alignment of 4, a minimum of 0 and of 1 only differ
+// for strings ending on a boundary, which get 0 or 4 bytes. If no entry
+// shows which, the larger minimum is taken, so new strings are never
+// padded less than the existing ones could have been.
+func padCandidates() [][2]int {
+	var candidates [][2]int
+	for _, align := range []int{8, 4, 2, 1} {
+		for min := maxMinPad; min >= 0; min-- {
+			candidates = append(candidates, [2]int{align, min})
+		}
 	}
-	return string(buf), nil
+	return candidates
 }
 
-func setTextPos(f *os.File) error {
-	buf := make([]byte, 1)
-	for {
-		_, err := f.Read(buf)
-		if err != nil {
-			return err
+// Reads the text fields of raw, given typePad null bytes after the type
+// string. ok is false if they don't fit: the padding isn't null, or the
+// text runs past the entry or is followed by anything but nulls.
+func readText(raw *rawEntry, typePad int) (int, bool) {
+	buf := raw.Buf
+	pos := raw.TypeEnd + typePad
+	if pos+8 > len(buf) || !isZero(buf[raw.TypeEnd:pos]) {
+		return 0, false
+	}
+	textLen := int32(binary.LittleEndian.Uint32(buf[pos:]))
+	textEnd := int64(pos) + 8 + int64(textLen)
+	if textLen < 0 || textEnd > int64(len(buf)) || !isZero(buf[textEnd:]) {
+		return 0, false
+	}
+	return int(textEnd), true
+}
+
+// Works out the padding rule every entry follows. The type string's
+// padding is tried first, each candidate in turn, until one lets every
+// text be read; the text padding must then follow one candidate too.
+// Fails with the entry that stopped the rule that got furthest.
+func findPadRule(raws []*rawEntry) (*PadRule, error) {
+	if len(raws) == 0 {
+		return nil, nil
+	}
+	var (
+		failed    int
+		failedMsg string
+		bestFit   = -1
+	)
+	fail := func(fits int, msg string) {
+		if fits > bestFit {
+			bestFit = fits
+			failed = fits
+			failedMsg = msg
 		}
-		if buf[0] == 0x20 {
-			_, err := f.Seek(-6, io.SeekCurrent)
-			return err
+	}
+	for _, typeRule := range padCandidates() {
+		textEnds := make([]int, len(raws))
+		fits := 0
+		for idx, raw := range raws {
+			textEnd, ok := readText(raw, padTo(typeRule[0], typeRule[1], raw.TypeEnd))
+			if !ok {
+				break
+			}
+			textEnds[idx] = textEnd
+			fits++
+		}
+		if fits < len(raws) {
+			fail(fits, "text length and padding don't fit the entry under any padding rule.")
+			continue
+		}
+		for _, textRule := range padCandidates() {
+			fits := 0
+			for idx, raw := range raws {
+				if len(raw.Buf)-textEnds[idx] != padTo(textRule[0], textRule[1], textEnds[idx]) {
+					break
+				}
+				fits++
+			}
+			if fits == len(raws) {
+				return &PadRule{
+					TypeAlign: typeRule[0],
+					TypeMin:   typeRule[1],
+					TextAlign: textRule[0],
+					TextMin:   textRule[1],
+				}, nil
+			}
+			fail(fits, "padding after the text doesn't follow the alignment of the other entries.")
 		}
 	}
+	return nil, entryError(failed, raws[failed].Pos, failedMsg)
 }
 
-func parseEntryHeader(f *os.File, scribe *Scribe) error {
-	unk, err := utils.ReadBytes(f, 8)
+func parseEntryHeader(r io.Reader, scribe *Scribe) error {
+	unk, err := utils.ReadBytes(r, 8)
 	if err != nil {
 		return err
 	}
-	entriesSize, err := utils.ReadUint32(f)
+	entriesSize, err := utils.ReadInt32(r)
 	if err != nil {
 		return err
 	}
-	unkTwo, err := utils.ReadUint32(f)
+	unkTwo, err := utils.ReadInt32(r)
 	if err != nil {
 		return err
 	}
@@ -72,78 +177,95 @@ func parseEntryHeader(f *os.File, scribe *Scribe) error {
 	return nil
 }
 
-func parseEntries(f *os.File, scribe *Scribe, endPos int64) error {
-	for {
-		startPos, err := utils.GetCurrentPos(f)
-		if err != nil {
-			return err
-		}
-		entrySize, err := utils.ReadUint32(f)
-		if err != nil {
-			return err
-		}
-		unk, err := utils.ReadUint32(f)
+// Entry layout, all little endian:
+// int32 size of the entry, this field included
+// int32 unk
+// 4 bytes unk_two
+// 12 bytes, null so far
+// int32 type string length, null terminator included
+// type string, then null padding
+// int32 text length
+// int32, 8192 so far
+// text, then null padding
+// The padding after the type string and the text follows a PadRule,
+// counted from the start of the entry; see findPadRule. Scribes whose
+// entries don't all follow one are read entry by entry instead, with
+// padRuleErr set.
+func parseEntries(r io.Reader, scribe *Scribe, endPos int64) error {
+	pos := int64(startOffset + 8 + 8)
+	entriesEnd := int64(startOffset+8) + int64(scribe.EntriesHeader.EntriesSize)
+	if entriesEnd < pos || entriesEnd > endPos {
+		return fmt.Errorf("Entries size %d doesn't fit the file.", scribe.EntriesHeader.EntriesSize)
+	}
+	var raws []*rawEntry
+	for idx := 0; pos < entriesEnd; idx++ {
+		entrySize, err := utils.ReadInt32(r)
 		if err != nil {
 			return err
 		}
-		unkTwo, err := utils.ReadBytes(f, 4)
-		if err != nil {
-			return err
+		if entrySize < minEntrySize {
+			return entryError(idx, pos, fmt.Sprintf("size %d is too small.", entrySize))
 		}
-		_, err = f.Seek(12, io.SeekCurrent)
-		if err != nil {
-			return err
+		if pos+int64(entrySize) > entriesEnd {
+			return entryError(idx, pos, fmt.Sprintf(
+				"size %d runs past the end of the entries at 0x%X.", entrySize, entriesEnd))
 		}
-		TypeStringLen, err := utils.ReadUint32(f)
+		rest, err := utils.ReadBytes(r, int64(entrySize)-4)
 		if err != nil {
 			return err
 		}
-		typeString, err := readString(f, TypeStringLen, true)
-		if err != nil {
-			return err
-		}
-		fmt.Println(typeString)
-		err = setTextPos(f)
-		if err != nil {
-			return err
+		buf := append(make([]byte, 4, entrySize), rest...)
+		typeStringLen := int32(binary.LittleEndian.Uint32(buf[24:]))
+		typeEnd := int64(28) + int64(typeStringLen)
+		if typeStringLen < 1 || typeEnd+8 > int64(entrySize) {
+			return entryError(idx, pos, fmt.Sprintf(
+				"type string length %d doesn't fit the entry.", typeStringLen))
 		}
-		textLen, err := utils.ReadUint32(f)
-		if err != nil {
-			return err
+		if buf[typeEnd-1] != 0x0 {
+			return entryError(idx, pos, "type string isn't null terminated.")
 		}
-		_, err = f.Seek(4, io.SeekCurrent)
-		if err != nil {
-			return err
-		}
-		text, err := readString(f, textLen, false)
-		if err != nil {
-			return err
+		raws = append(raws, &rawEntry{Pos: pos, Buf: buf, TypeEnd: int(typeEnd)})
+		pos += int64(entrySize)
+	}
+	scribe.PadRule, scribe.padRuleErr = findPadRule(raws)
+	for idx, raw := range raws {
+		buf := raw.Buf
+		var (
+			typePad int
+			textEnd int
+			ok      bool
+		)
+		if rule := scribe.PadRule; rule != nil {
+			typePad = padTo(rule.TypeAlign, rule.TypeMin, raw.TypeEnd)
+			textEnd, _ = readText(raw, typePad)
+		} else {
+			// No rule fits, so each entry's padding is found on its
+			// own, the least that lets the text be read.
+			for typePad = 0; typePad <= maxPad; typePad++ {
+				textEnd, ok = readText(raw, typePad)
+				if ok {
+					break
+				}
+			}
+			if !ok {
+				return entryError(idx, raw.Pos, "text length and padding don't fit the entry.")
+			}
 		}
+		textPad := len(buf) - textEnd
+		textPos := raw.TypeEnd + typePad
+		textUnk := int32(binary.LittleEndian.Uint32(buf[textPos+4:]))
 		scribe.Entries = append(scribe.Entries, &Entry{
-			TypeString: typeString,
-			Text:       text,
-			Unk:        unk,
-			UnkTwo:     unkTwo,
+			TypeString: string(buf[28 : raw.TypeEnd-1]),
+			Unk:        int32(binary.LittleEndian.Uint32(buf[4:])),
+			UnkTwo:     buf[8:12],
+			UnkThree:   buf[12:24],
+			TypePad:    &typePad,
+			TextUnk:    &textUnk,
+			Text:       string(buf[textPos+8 : textEnd]),
+			TextPad:    &textPad,
 		})
-		fmt.Println(text)
-		nextEntryOffset := startPos + int64(entrySize)
-		if nextEntryOffset-startOffset-8 == int64(scribe.EntriesHeader.EntriesSize) {
-			_, err = f.Seek(4, io.SeekCurrent)
-			if err != nil {
-				return err
-			}
-			break
-		}
-		_, err = f.Seek(nextEntryOffset, io.SeekStart)
-		if err != nil {
-			return err
-		}
 	}
-	curPos, err := utils.GetCurrentPos(f)
-	if err != nil {
-		return err
-	}
-	endData, err := utils.ReadBytes(f, endPos-curPos)
+	endData, err := utils.ReadBytes(r, endPos-entriesEnd)
 	if err != nil {
 		return err
 	}
@@ -170,184 +292,389 @@ func test(scribe *Scribe) {
 	scribe.EntriesHeader.EntriesSize = entriesSize
 }
 
-func writeScribe(scribe *Scribe, outPath string) error {
-	f, err := os.OpenFile(outPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+func writeEntry(w io.Writer, entry *Entry) error {
+	err := utils.WriteInt32(w, entry.Size)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	_, err = f.Write(magic[:])
+	err = utils.WriteInt32(w, entry.Unk)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(entry.UnkTwo)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(entry.UnkThree)
+	if err != nil {
+		return err
+	}
+	err = utils.WriteInt32(w, entry.TypeStringLen)
 	if err != nil {
 		return err
 	}
-	_, err = f.Write(bytes.Repeat([]byte{0x0}, 316))
+	_, err = io.WriteString(w, entry.TypeString)
 	if err != nil {
 		return err
 	}
-	_, err = f.Write(scribe.EntriesHeader.Unk)
+	// Terminator and padding.
+	err = utils.WriteNull(w, 1+*entry.TypePad)
 	if err != nil {
 		return err
 	}
-	err = utils.WriteUint32(f, scribe.EntriesHeader.EntriesSize)
+	err = utils.WriteInt32(w, entry.TextLen)
 	if err != nil {
 		return err
 	}
-	err = utils.WriteUint32(f, scribe.EntriesHeader.UnkTwo)
+	err = utils.WriteInt32(w, *entry.TextUnk)
 	if err != nil {
 		return err
 	}
+	_, err = io.WriteString(w, entry.Text)
+	if err != nil {
+		return err
+	}
+	return utils.WriteNull(w, *entry.TextPad)
+}
+
+func setDefaults(entry *Entry) {
+	if len(entry.UnkThree) == 0 {
+		entry.UnkThree = make([]byte, unkThreeSize)
+	}
+	if entry.TypePad == nil {
+		typePad := defaultTypePad
+		entry.TypePad = &typePad
+	}
+	if entry.TextUnk == nil {
+		textUnk := int32(defaultTextUnk)
+		entry.TextUnk = &textUnk
+	}
+	if entry.TextPad == nil {
+		textPad := defaultTextPad
+		entry.TextPad = &textPad
+	}
+}
+
+// Pads every entry by the padding rule, so entries whose strings
+// changed length stay in line with the rest; the others come out as
+// they were. Without a rule, for JSON from before it was kept, one is
+// worked out from the pads given, and failing that they're kept.
+func setPads(scribe *Scribe) {
+	rule := scribe.PadRule
+	if rule == nil {
+		rule = ruleFromPads(scribe.Entries)
+	}
+	if rule == nil {
+		return
+	}
 	for _, entry := range scribe.Entries {
-		err = utils.WriteUint32(f, entry.Size)
-		if err != nil {
-			return err
-		}
-		err = utils.WriteUint32(f, entry.Unk)
-		if err != nil {
-			return err
-		}
-		_, err = f.Write(entry.UnkTwo)
-		if err != nil {
-			return err
-		}
-		err = utils.WriteNull(f, 12)
-		if err != nil {
-			return err
-		}
-		err = utils.WriteUint32(f, entry.TypeStringLen)
-		if err != nil {
-			return err
-		}
-		_, err = f.WriteString(entry.TypeString)
-		if err != nil {
-			return err
-		}
-		err = utils.WriteNull(f, 3)
-		if err != nil {
-			return err
-		}
-		err = utils.WriteUint32(f, entry.TextLen)
-		if err != nil {
-			return err
+		typePad := padTo(rule.TypeAlign, rule.TypeMin, typeEnd(entry))
+		entry.TypePad = &typePad
+		textPad := padTo(rule.TextAlign, rule.TextMin, textEnd(entry))
+		entry.TextPad = &textPad
+	}
+}
+
+// Sets the length and size fields from the strings and padding.
+func setSizes(scribe *Scribe) {
+	var entriesSize int32 = 8
+	for _, entry := range scribe.Entries {
+		entry.TypeStringLen = int32(len(entry.TypeString)) + 1
+		entry.TextLen = int32(len(entry.Text))
+		entry.Size = 28 + entry.TypeStringLen + int32(*entry.TypePad) +
+			8 + entry.TextLen + int32(*entry.TextPad)
+		entriesSize += entry.Size
+	}
+	scribe.EntriesHeader.EntriesSize = entriesSize
+}
+
+// Gets scribe ready to write: fills in new entries and defaults, checks
+// the fields' sizes, and works out the padding, sizes and index.
+func prepare(scribe *Scribe) error {
+	if scribe.EntriesHeader == nil || len(scribe.EntriesHeader.Unk) != 8 {
+		return errors.New("Scribe entries header must have 8 unk bytes.")
+	}
+	if len(scribe.Header) != 0 && len(scribe.Header) != headerSize {
+		return errors.New("Scribe header must be " + strconv.Itoa(headerSize) + " bytes.")
+	}
+	_, err := fillNewEntries(scribe)
+	if err != nil {
+		return err
+	}
+	for idx, entry := range scribe.Entries {
+		setDefaults(entry)
+		if len(entry.UnkTwo) != 4 {
+			return fmt.Errorf("Entry %d: unk_two must be 4 bytes.", idx)
 		}
-		err = utils.WriteUint32(f, 8192)
-		if err != nil {
-			return err
+		if len(entry.UnkThree) != unkThreeSize {
+			return fmt.Errorf("Entry %d: unk_three must be %d bytes.", idx, unkThreeSize)
 		}
-		_, err = f.WriteString(entry.Text)
-		if err != nil {
-			return err
+		if *entry.TypePad < 0 || *entry.TextPad < 0 {
+			return fmt.Errorf("Entry %d: type_pad and text_pad can't be negative.", idx)
 		}
-		err = utils.WriteNull(f, 2)
+	}
+	if scribe.Index == nil && scribe.EndDataEntries != 0 && len(scribe.Entries) != scribe.EndDataEntries {
+		return fmt.Errorf(
+			"Entries were added or removed (%d, was %d), but the data after them isn't a known index "+
+				"and can't be updated. Remove end_data_entries from the JSON to write it anyway.",
+			len(scribe.Entries), scribe.EndDataEntries)
+	}
+	setPads(scribe)
+	setSizes(scribe)
+	if scribe.Index != nil {
+		scribe.EndData, err = encodeIndex(scribe, scribe.Index)
 		if err != nil {
 			return err
 		}
 	}
-	_, err = f.Write(scribe.EndData)
-	return err
+	setLayout(scribe)
+	return nil
 }
 
-func parseScribeJson(path string) (*Scribe, error) {
-	data, err := ioutil.ReadFile(path)
+// Decode parses a scribe file. Header fields holding a layout value are
+// listed in HeaderCandidates. Data after the entries that's recognised
+// as an index is decoded into Index, otherwise it's kept in EndData.
+func Decode(r io.Reader) (*Scribe, error) {
+	data, err := io.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
-	var obj Scribe
-	err = json.Unmarshal(data, &obj)
+	br := bytes.NewReader(data)
+	ok, err := checkMagic(br)
 	if err != nil {
 		return nil, err
 	}
-	decUnk, err := utils.B64Decode(obj.EntriesHeader.UnkB64)
+	if !ok {
+		return nil, errors.New("File is not a scribe file.")
+	}
+	scribe := &Scribe{
+		Entries: []*Entry{},
+	}
+	scribe.Header, err = utils.ReadBytes(br, headerSize)
 	if err != nil {
 		return nil, err
 	}
-	decEndData, err := utils.B64Decode(obj.EndDataB64)
+	err = parseEntryHeader(br, scribe)
 	if err != nil {
 		return nil, err
 	}
-	obj.EntriesHeader.Unk = decUnk
-	obj.EndData = decEndData
-	var entriesSize int32 = 8
-	for idx, entry := range obj.Entries {
-		decUnkTwo, err := utils.B64Decode(entry.UnkTwoB64)
-		if err != nil {
-			return nil, err
-		}
-		obj.Entries[idx].UnkTwo = decUnkTwo
-		typeStringLen := int32(len(entry.TypeString)) + 1
-		textLen := int32(len(entry.Text))
-		entrySize := typeStringLen + textLen + 40
-		obj.Entries[idx].TypeStringLen = typeStringLen
-		obj.Entries[idx].TextLen = textLen
-		obj.Entries[idx].Size = entrySize
-		entriesSize += entrySize
+	err = parseEntries(br, scribe, int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	setSizes(scribe)
+	setLayout(scribe)
+	scribe.HeaderCandidates = findHeaderCandidates(scribe.Header, &scribe.Layout)
+	scribe.Index = decodeIndex(scribe)
+	if scribe.Index != nil {
+		scribe.EndData = nil
+	} else if len(scribe.EndData) > 0 {
+		scribe.EndDataEntries = len(scribe.Entries)
 	}
-	obj.EntriesHeader.EntriesSize = entriesSize
-	return &obj, nil
+	return scribe, nil
 }
 
-func writeJson(scribe *Scribe, outPath string) error {
-	outScribe := scribe
-	outScribe.EntriesHeader.EntriesSize = 0
-	outScribe.EntriesHeader.UnkB64 = utils.B64Encode(outScribe.EntriesHeader.Unk)
-	outScribe.EntriesHeader.Unk = nil
-	outScribe.EndDataB64 = utils.B64Encode(outScribe.EndData)
-	outScribe.EndData = nil
-	for idx, entry := range outScribe.Entries {
-		outScribe.Entries[idx].UnkTwoB64 = utils.B64Encode(entry.UnkTwo)
-		outScribe.Entries[idx].UnkTwo = nil
+// Copy of scribe that prepare can fill in without touching scribe.
+// Fields are only ever replaced, not changed in place, so the entries
+// and entries header are all that need copying.
+func copyScribe(scribe *Scribe) *Scribe {
+	out := *scribe
+	if scribe.EntriesHeader != nil {
+		entriesHeader := *scribe.EntriesHeader
+		out.EntriesHeader = &entriesHeader
+	}
+	out.Entries = make([]*Entry, len(scribe.Entries))
+	for idx, entry := range scribe.Entries {
+		outEntry := *entry
+		out.Entries[idx] = &outEntry
+	}
+	return &out
+}
+
+// Encode writes scribe as a scribe file. New entries (those without
+// UnkTwo) are filled in, and the sizes, padding, header fields and
+// index are worked out from the entries, on a copy; scribe is left as
+// it is.
+func Encode(w io.Writer, scribe *Scribe) error {
+	scribe = copyScribe(scribe)
+	err := prepare(scribe)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	_, err = bw.Write(magic[:])
+	if err != nil {
+		return err
+	}
+	// JSON from before the header was kept has none; zeros are what
+	// was always written then.
+	header := append([]byte{}, scribe.Header...)
+	if len(header) == 0 {
+		header = bytes.Repeat([]byte{0x0}, headerSize)
+	}
+	err = writeHeaderFields(header, scribe.HeaderFields, &scribe.Layout)
+	if err != nil {
+		return err
+	}
+	_, err = bw.Write(header)
+	if err != nil {
+		return err
+	}
+	_, err = bw.Write(scribe.EntriesHeader.Unk)
+	if err != nil {
+		return err
+	}
+	err = utils.WriteInt32(bw, scribe.EntriesHeader.EntriesSize)
+	if err != nil {
+		return err
 	}
-	m, err := json.MarshalIndent(outScribe, "", "\t")
+	err = utils.WriteInt32(bw, scribe.EntriesHeader.UnkTwo)
 	if err != nil {
 		return err
 	}
-	err = ioutil.WriteFile(outPath, m, 0755)
-	return err
+	for _, entry := range scribe.Entries {
+		err = writeEntry(bw, entry)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = bw.Write(scribe.EndData)
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func readScribe(path string) (*Scribe, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Decode(f)
 }
 
 // JSON to scribe.
 func To(args *utils.Args) error {
-	scribe, err := parseScribeJson(args.InPaths[0])
+	f, err := os.Open(args.InPaths[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scribe, err := DecodeJSON(f)
 	if err != nil {
 		return err
 	}
-	err = writeScribe(scribe, args.OutPath)
-	return err
+	var added int
+	for _, entry := range scribe.Entries {
+		if isNew(entry) {
+			added++
+		}
+	}
+	out, err := utils.CreateAtomic(args.OutPath)
+	if err != nil {
+		return err
+	}
+	defer out.Abort()
+	err = Encode(out, scribe)
+	if err != nil {
+		return err
+	}
+	if added > 0 {
+		fmt.Printf("Added %d new entries.\n", added)
+		if len(scribe.HeaderCandidates) > len(scribe.HeaderFields) {
+			fmt.Println("Header candidates not in header_fields were left as they were.")
+		}
+	}
+	return out.Commit()
 }
 
-// Scribe to JSON.
+// Scribe to JSON. Any further input paths are sample scribes, used to
+// confirm header fields.
 func From(args *utils.Args) error {
-	scribe := &Scribe{
-		EntriesHeader: &EntriesHeader{},
-		Entries:       []*Entry{},
+	scribe, err := readScribe(args.InPaths[0])
+	if err != nil {
+		return err
+	}
+	var samples []*Scribe
+	for _, path := range args.InPaths[1:] {
+		sample, err := readScribe(path)
+		if err != nil {
+			return err
+		}
+		samples = append(samples, sample)
 	}
-	f, err := os.OpenFile(args.InPaths[0], os.O_RDONLY, 0755)
+	scribe.HeaderFields = confirmHeaderFields(scribe, samples)
+	for _, entry := range scribe.Entries {
+		fmt.Println(entry.TypeString)
+		fmt.Println(entry.Text)
+	}
+	if len(scribe.HeaderCandidates) > 0 {
+		fmt.Println("Possible header fields: " +
+			strings.Join(headerFieldNames(scribe.HeaderCandidates), ", ") + ".")
+	}
+	if len(scribe.HeaderFields) > 0 {
+		fmt.Println("Header fields confirmed by the samples, rewritten on write: " +
+			strings.Join(headerFieldNames(scribe.HeaderFields), ", ") + ".")
+	} else if len(scribe.HeaderCandidates) > 0 {
+		fmt.Println("These may be coincidence, so they're kept as is. " +
+			"Copy one to header_fields in the JSON, or give more scribes as inputs, to have it rewritten.")
+	}
+	if scribe.padRuleErr != nil {
+		fmt.Println("WARNING: Entries don't follow one padding rule (" + scribe.padRuleErr.Error() + ") " +
+			"Each entry's padding is kept as it is, so edited or new strings may not be padded like the rest.")
+	}
+	if scribe.Index != nil {
+		fmt.Println("Trailing data is an index over the entries; it will be rebuilt on write.")
+	} else if len(scribe.EndData) > 0 {
+		fmt.Println("WARNING: Trailing data isn't the entry index (a count, then each entry's unk and offset). " +
+			"It's kept as is, so it won't follow edits, and adding or removing entries is refused.")
+	}
+	out, err := utils.CreateAtomic(args.OutPath)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	ok, err := checkMagic(f)
+	defer out.Abort()
+	err = EncodeJSON(out, scribe)
 	if err != nil {
 		return err
 	}
-	if !ok {
-		return errors.New("File is not a scribe file.")
+	return out.Commit()
+}
+
+// Converts the scribe at path to JSON and back, and checks the result
+// is byte-identical to the original.
+func RoundTrip(path string) error {
+	orig, err := os.ReadFile(path)
+	if err != nil {
+		return err
 	}
-	stat, err := f.Stat()
+	scribe, err := Decode(bytes.NewReader(orig))
 	if err != nil {
 		return err
 	}
-	_, err = f.Seek(startOffset, io.SeekStart)
+	var js bytes.Buffer
+	err = EncodeJSON(&js, scribe)
 	if err != nil {
 		return err
 	}
-	err = parseEntryHeader(f, scribe)
+	scribe, err = DecodeJSON(&js)
 	if err != nil {
 		return err
 	}
-	err = parseEntries(f, scribe, stat.Size())
+	var out bytes.Buffer
+	err = Encode(&out, scribe)
 	if err != nil {
 		return err
 	}
-	err = writeJson(scribe, args.OutPath)
-	return err
+	rebuilt := out.Bytes()
+	for i := 0; i < len(orig) && i < len(rebuilt); i++ {
+		if orig[i] != rebuilt[i] {
+			return fmt.Errorf("Round trip differs at 0x%X.", i)
+		}
+	}
+	if len(orig) != len(rebuilt) {
+		return fmt.Errorf("Round trip is %d bytes, the original %d.", len(rebuilt), len(orig))
+	}
+	return nil
 }
@@ -0,0 +1,216 @@
+package scribe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newScribe() *Scribe {
+	return &Scribe{
+		EntriesHeader: &EntriesHeader{Unk: make([]byte, 8), UnkTwo: 77},
+		PadRule:       &PadRule{TypeAlign: 4, TypeMin: 1, TextAlign: 4, TextMin: 1},
+		Index:         &Index{Fields: indexFields},
+		Entries: []*Entry{
+			{TypeString: "mission_title", Text: "The Heist"},
+			{TypeString: "ui_ok", Text: "OK"},
+			{TypeString: "x", Text: ""},
+		},
+	}
+}
+
+func TestEncodeLeavesInputUnchanged(t *testing.T) {
+	scribe := newScribe()
+	want := newScribe()
+	var out bytes.Buffer
+	err := Encode(&out, scribe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(scribe, want) {
+		t.Errorf("Encode changed its input")
+	}
+	var csv bytes.Buffer
+	err = EncodeCSV(&csv, scribe, ',')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(scribe, want) {
+		t.Errorf("EncodeCSV changed its input")
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	var first bytes.Buffer
+	err := Encode(&first, newScribe())
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(bytes.NewReader(first.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Index == nil {
+		t.Errorf("index wasn't recognised")
+	}
+	var again bytes.Buffer
+	err = Encode(&again, decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Bytes(), first.Bytes()) {
+		t.Errorf("decoded scribe encodes differently")
+	}
+	decoded.Entries[0].Text = "A longer title than before"
+	var second bytes.Buffer
+	err = Encode(&second, decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited, err := Decode(bytes.NewReader(second.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.Entries[0].Text != "A longer title than before" || edited.Index == nil {
+		t.Errorf("edit didn't survive: %q", edited.Entries[0].Text)
+	}
+}
+
+func encodeDecode(t *testing.T, scribe *Scribe) (*Scribe, []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	err := Encode(&buf, scribe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded, buf.Bytes()
+}
+
+// A scribe whose header holds its own file size at 0x14 and an
+// unrelated 3 at 0x24, with more entries than newScribe.
+func headerScribe(t *testing.T, more int) *Scribe {
+	scribe := newScribe()
+	for i := 0; i < more; i++ {
+		scribe.Entries = append(scribe.Entries, &Entry{TypeString: fmt.Sprintf("extra_%d", i), Text: "More text"})
+	}
+	scribe.Header = make([]byte, headerSize)
+	binary.LittleEndian.PutUint32(scribe.Header[0x20:], 3)
+	decoded, _ := encodeDecode(t, scribe)
+	binary.LittleEndian.PutUint32(scribe.Header[0x10:], uint32(decoded.Layout.FileSize))
+	decoded, _ = encodeDecode(t, scribe)
+	return decoded
+}
+
+func TestHeaderFieldsNeedConfirming(t *testing.T) {
+	scribe := headerScribe(t, 0)
+	want := map[string]int{"file_size": 0x14}
+	if !reflect.DeepEqual(scribe.HeaderCandidates, want) {
+		t.Errorf("candidates = %v, want %v", scribe.HeaderCandidates, want)
+	}
+	if scribe.HeaderFields != nil {
+		t.Errorf("fields = %v, want none before confirming", scribe.HeaderFields)
+	}
+	oldSize := scribe.Layout.FileSize
+	scribe.Entries = append(scribe.Entries, &Entry{TypeString: "added", Text: "New"})
+	_, data := encodeDecode(t, scribe)
+	if got := binary.LittleEndian.Uint32(data[0x14:]); got != uint32(oldSize) {
+		t.Errorf("unconfirmed file_size rewritten to %d, was %d", got, oldSize)
+	}
+	if got := binary.LittleEndian.Uint32(data[0x24:]); got != 3 {
+		t.Errorf("unrelated field at 0x24 rewritten to %d", got)
+	}
+
+	scribe = headerScribe(t, 0)
+	if fields := confirmHeaderFields(scribe, []*Scribe{headerScribe(t, 0)}); fields != nil {
+		t.Errorf("samples with the same value confirmed %v", fields)
+	}
+	scribe.HeaderFields = confirmHeaderFields(scribe, []*Scribe{headerScribe(t, 2)})
+	if !reflect.DeepEqual(scribe.HeaderFields, want) {
+		t.Fatalf("confirmed %v, want %v", scribe.HeaderFields, want)
+	}
+	scribe.Entries = append(scribe.Entries, &Entry{TypeString: "added", Text: "New"})
+	_, data = encodeDecode(t, scribe)
+	if got := binary.LittleEndian.Uint32(data[0x14:]); got != uint32(len(data)) {
+		t.Errorf("confirmed file_size is %d, file is %d bytes", got, len(data))
+	}
+	if got := binary.LittleEndian.Uint32(data[0x24:]); got != 3 {
+		t.Errorf("unrelated field at 0x24 rewritten to %d", got)
+	}
+}
+
+// No entry ends on a 4 byte boundary, so a minimum pad of 0 fits the
+// entries as well as the 1 they were written with. The last entry is
+// padded by 1 byte, which rules out anything larger.
+func TestAmbiguousPadRule(t *testing.T) {
+	scribe := newScribe()
+	scribe.Entries[2] = &Entry{TypeString: "xy", Text: "Yes"}
+	decoded, _ := encodeDecode(t, scribe)
+	want := &PadRule{TypeAlign: 4, TypeMin: 1, TextAlign: 4, TextMin: 1}
+	if !reflect.DeepEqual(decoded.PadRule, want) {
+		t.Fatalf("pad rule = %+v, want %+v", decoded.PadRule, want)
+	}
+	// Both strings of this one end on a boundary.
+	decoded.Entries = append(decoded.Entries, &Entry{TypeString: "abc", Text: "Done"})
+	added, _ := encodeDecode(t, decoded)
+	entry := added.Entries[len(added.Entries)-1]
+	if *entry.TypePad != 4 || *entry.TextPad != 4 {
+		t.Errorf("new entry pads = %d, %d, want 4, 4", *entry.TypePad, *entry.TextPad)
+	}
+}
+
+func TestNoPadRuleKeepsPads(t *testing.T) {
+	pads := [][2]int{{2, 2}, {5, 1}, {3, 7}}
+	scribe := newScribe()
+	scribe.PadRule = nil
+	scribe.Entries[2].Text = "Y"
+	for idx, entry := range scribe.Entries {
+		typePad, textPad := pads[idx][0], pads[idx][1]
+		entry.TypePad, entry.TextPad = &typePad, &textPad
+	}
+	decoded, data := encodeDecode(t, scribe)
+	if decoded.PadRule != nil || decoded.padRuleErr == nil {
+		t.Fatalf("pad rule = %+v, error %v, want none and an error", decoded.PadRule, decoded.padRuleErr)
+	}
+	for idx, entry := range decoded.Entries {
+		if *entry.TypePad != pads[idx][0] || *entry.TextPad != pads[idx][1] {
+			t.Errorf("entry %d pads = %d, %d, want %v", idx, *entry.TypePad, *entry.TextPad, pads[idx])
+		}
+	}
+	_, again := encodeDecode(t, decoded)
+	if !bytes.Equal(again, data) {
+		t.Errorf("scribe without a pad rule encodes differently")
+	}
+}
+
+// Entry errors name the entry and its offset in the file.
+func TestEntryErrors(t *testing.T) {
+	_, data := encodeDecode(t, newScribe())
+	second := entriesOffset + int(binary.LittleEndian.Uint32(data[entriesOffset:]))
+	tests := []struct {
+		field int
+		value uint32
+		err   string
+	}{
+		{0, 3, "size 3 is too small."},
+		{0, 1 << 20, "runs past the end of the entries"},
+		{24, 1000, "type string length 1000 doesn't fit the entry."},
+		{24, 2, "type string isn't null terminated."},
+		{34, 1000, "text length and padding don't fit the entry."},
+	}
+	for _, test := range tests {
+		broken := append([]byte{}, data...)
+		binary.LittleEndian.PutUint32(broken[second+test.field:], test.value)
+		_, err := Decode(bytes.NewReader(broken))
+		want := fmt.Sprintf("Entry 1 at 0x%X: ", second)
+		if err == nil || !strings.HasPrefix(err.Error(), want) || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("field %d = %d: got %v, want %s...%s", test.field, test.value, err, want, test.err)
+		}
+	}
+}
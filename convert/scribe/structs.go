@@ -12,19 +12,108 @@ type Entry struct {
 	Unk       int32  `json:"unk"`
 	UnkTwo    []byte `json:",omitempty"`
 	UnkTwoB64 string `json:"unk_two"`
-	// 12 null
+	// 12 bytes, null so far.
+	UnkThree      []byte `json:",omitempty"`
+	UnkThreeB64   string `json:"unk_three,omitempty"`
 	TypeStringLen int32  `json:",omitempty"`
 	TypeString    string `json:"type_string"`
-	// 2-8 null, seems arbitrary
+	// Null bytes after the type string's terminator.
+	TypePad *int  `json:"type_pad,omitempty"`
 	TextLen int32 `json:",omitempty"`
-	// int32, 8192
-	Text string `json:"text"`
-	// 2-8 null, seems arbitrary
+	// 8192 so far.
+	TextUnk *int32 `json:"text_unk,omitempty"`
+	Text    string `json:"text"`
+	// Null bytes after the text.
+	TextPad *int `json:"text_pad,omitempty"`
 }
 
 type Scribe struct {
-	EntriesHeader *EntriesHeader `json:"entries_header"`
-	Entries       []*Entry       `json:"entries"`
-	EndData       []byte         `json:",omitempty"`
-	EndDataB64    string         `json:"end_data"`
+	// Bytes 4 to 0x140, after the magic. Kept as they are, apart from
+	// the fields in HeaderFields.
+	Header    []byte `json:",omitempty"`
+	HeaderB64 string `json:"header,omitempty"`
+	// Offsets from the start of the file of header fields confirmed to
+	// hold a Layout value, by name: entry_count, entries_size,
+	// end_offset, end_size or file_size. They're written from the
+	// entries on Encode.
+	HeaderFields map[string]int `json:"header_fields,omitempty"`
+	// Header fields that held a Layout value in this file, in the same
+	// form. Only reported; see header.go.
+	HeaderCandidates map[string]int `json:"header_candidates,omitempty"`
+	Layout           Layout         `json:"-"`
+	EntriesHeader    *EntriesHeader `json:"entries_header"`
+	// Padding rule of the entries, worked out on Decode. New entries are
+	// padded by it. Nil when the entries don't follow one, with the
+	// reason in padRuleErr; their pads are then kept per entry.
+	PadRule    *PadRule `json:"pad_rule,omitempty"`
+	padRuleErr error
+	Entries    []*Entry `json:"entries"`
+	// Set when the data after the entries is the index over them, which
+	// is then rebuilt from the entries on write. Otherwise the data is
+	// kept as it is.
+	Index      *Index `json:"index,omitempty"`
+	EndData    []byte `json:",omitempty"`
+	EndDataB64 string `json:"end_data"`
+	// Entry count when EndData was kept as it is. Encode refuses to
+	// write a different number of entries, as EndData may depend on
+	// them.
+	EndDataEntries int `json:"end_data_entries,omitempty"`
+}
+
+// Sizes and offsets worked out from the entries and the data after
+// them.
+type Layout struct {
+	EntryCount  int32
+	EntriesSize int32
+	// Where the data after the entries starts, and its size.
+	EndOffset int32
+	EndSize   int32
+	FileSize  int32
+}
+
+// The index after the entries; see index.go.
+type Index struct {
+	// What each record holds: unk, offset. Nothing else is accepted.
+	Fields []string `json:"fields"`
+}
+
+// How much null padding follows the type string and the text in each
+// entry: the least that's at least the minimum and ends the padding,
+// counted from the start of the entry, on a multiple of the alignment.
+type PadRule struct {
+	TypeAlign int `json:"type_align"`
+	TypeMin   int `json:"type_min"`
+	TextAlign int `json:"text_align"`
+	TextMin   int `json:"text_min"`
+}
+
+// An entry as read, before its text is parsed.
+type rawEntry struct {
+	// Offset in the file.
+	Pos int64
+	Buf []byte
+	// Offset in Buf just past the type string's terminator.
+	TypeEnd int
+}
+
+// What ApplyCSV or ApplyPO did.
+type ImportReport struct {
+	Updated int
+	// Entries given the source text because their translation was
+	// fuzzy or empty. PO only.
+	Fallback int
+	// Type strings in the import with no entry in the scribe. Skipped.
+	Unknown []string
+	// Entries not in the import, by type string, with the index for one
+	// shared by more than one entry. Left as they were.
+	Missing []string
+}
+
+// A message read from a PO file.
+type poEntry struct {
+	HasContext bool
+	Context    string
+	ID         string
+	Str        string
+	Fuzzy      bool
 }
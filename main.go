@@ -1,11 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"main/convert"
 	"main/pack"
+	"main/patch"
 	"main/unpack"
 	"main/utils"
+	"main/verify"
 	"strings"
 	"time"
 
@@ -16,6 +19,9 @@ func parseArgs() (*utils.Args, error) {
 	var args utils.Args
 	arg.MustParse(&args)
 	args.Command = strings.ToLower(args.Command)
+	if args.Command != "prunecache" && len(args.InPaths) == 0 {
+		return nil, errors.New("Input path(s) required.")
+	}
 	return &args, nil
 }
 
@@ -25,17 +31,29 @@ func main() {
 		panic(err)
 	}
 	command := args.Command
+	utils.HandleSignals()
 	now := time.Now()
 	switch command {
+	case "build":
+		err = patch.Build(args)
 	case "convert":
 		err = convert.Run(args)
 	case "pack":
 		err = pack.Run(args)
+	case "patch":
+		err = patch.Run(args)
+	case "roundtrip":
+		err = convert.RoundTrip(args)
+	case "prunecache":
+		err = pack.PruneCache(args)
 	case "unpack", "extract":
 		err = unpack.Run(args)
+	case "verify":
+		err = verify.Run(args)
 	default:
 		panic("Unknown command: " + command)
 	}
+	utils.ExitIfInterrupted()
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,176 @@
+package pack
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"main/utils"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Compressed output is cached by content hash and lz4 arguments, so
+// files that haven't changed since an earlier run aren't compressed
+// again.
+
+func lz4Args(level int) []string {
+	return []string{"-" + strconv.Itoa(level), "-B5D"}
+}
+
+// Returns the cache folder: cacheDir as given if set, otherwise
+// SRTools/lz4 in the user cache folder.
+func CacheDir(cacheDir string) (string, error) {
+	if cacheDir != "" {
+		return cacheDir, nil
+	}
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "SRTools", "lz4"), nil
+}
+
+func cacheKey(hash string, level int) string {
+	sum := sha256.Sum256([]byte(hash + " " + strings.Join(lz4Args(level), " ")))
+	return hex.EncodeToString(sum[:])
+}
+
+func cachePath(cacheDir, key string) string {
+	return filepath.Join(cacheDir, key[:2], key+".lz4")
+}
+
+// Compresses file through the cache. A hit is touched so pruning by age
+// keeps it; a miss is compressed into a temp file in the cache and
+// renamed into place, so an interrupted run can't leave a partial entry.
+func compressCached(file *File, cacheDir string) (string, uint64, bool, error) {
+	if file.Hash == "" {
+		hash, err := hashFile(file.FullPath)
+		if err != nil {
+			return "", 0, false, err
+		}
+		file.Hash = hash
+	}
+	outPath := cachePath(cacheDir, cacheKey(file.Hash, file.Level))
+	stat, err := os.Stat(outPath)
+	if err == nil {
+		now := time.Now()
+		err = os.Chtimes(outPath, now, now)
+		if err != nil {
+			return "", 0, false, err
+		}
+		return outPath, uint64(stat.Size()), true, nil
+	}
+	err = os.MkdirAll(filepath.Dir(outPath), 0755)
+	if err != nil {
+		return "", 0, false, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(outPath), "*.tmp")
+	if err != nil {
+		return "", 0, false, err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	utils.AddCleanup(tmpPath)
+	defer utils.RemoveCleanup(tmpPath)
+	// lz4 won't overwrite an existing file.
+	err = os.Remove(tmpPath)
+	if err != nil {
+		return "", 0, false, err
+	}
+	compSize, err := runLz4(file.FullPath, tmpPath, file.Level)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", 0, false, err
+	}
+	err = os.Rename(tmpPath, outPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", 0, false, err
+	}
+	return outPath, compSize, false, nil
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
+
+// Whether name, in the cache folder sub folder subDir, is a cached file
+// or a temp file compressCached left behind.
+func isCacheFile(subDir, name string) bool {
+	if strings.HasSuffix(name, ".tmp") {
+		_, err := strconv.ParseUint(strings.TrimSuffix(name, ".tmp"), 10, 64)
+		return err == nil
+	}
+	key := strings.TrimSuffix(name, ".lz4")
+	return key != name && isHex(key, 64) && key[:2] == subDir
+}
+
+// Removes cached files not used in the last args.MaxAge days, or all of
+// them if it's 0. Only the cache's own layout is touched: files named
+// <key>.lz4 and compression temp files, in folders named by the key's
+// first two hex digits. Anything else in the folder is left alone.
+func PruneCache(args *utils.Args) error {
+	if args.MaxAge < 0 {
+		return errors.New("Max age can't be negative.")
+	}
+	cacheDir, err := CacheDir(args.CacheDir)
+	if err != nil {
+		return err
+	}
+	subDirs, err := os.ReadDir(cacheDir)
+	if os.IsNotExist(err) {
+		fmt.Println("Cache is empty.")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().AddDate(0, 0, -args.MaxAge)
+	var (
+		removed int
+		freed   int64
+		kept    int
+	)
+	fmt.Println("Pruning " + cacheDir + "...")
+	for _, subDir := range subDirs {
+		if !subDir.IsDir() || !isHex(subDir.Name(), 2) {
+			continue
+		}
+		subPath := filepath.Join(cacheDir, subDir.Name())
+		files, err := os.ReadDir(subPath)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if !file.Type().IsRegular() || !isCacheFile(subDir.Name(), file.Name()) {
+				continue
+			}
+			info, err := file.Info()
+			if err != nil {
+				return err
+			}
+			if args.MaxAge > 0 && info.ModTime().After(cutoff) {
+				kept++
+				continue
+			}
+			err = os.Remove(filepath.Join(subPath, file.Name()))
+			if err != nil {
+				return err
+			}
+			removed++
+			freed += info.Size()
+		}
+		// Only an empty folder goes; the error otherwise is expected.
+		os.Remove(subPath)
+	}
+	fmt.Printf("Removed %d cached file(s), freeing %d bytes. %d kept.\n", removed, freed, kept)
+	return nil
+}
@@ -0,0 +1,50 @@
+package pack
+
+import (
+	"main/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPruneCacheOnlyRemovesCacheFiles(t *testing.T) {
+	cacheDir := t.TempDir()
+	key := cacheKey("hash", 9)
+	removed := []string{
+		cachePath(cacheDir, key),
+		filepath.Join(cacheDir, key[:2], "123456.tmp"),
+	}
+	kept := []string{
+		filepath.Join(cacheDir, "notes.txt"),
+		filepath.Join(cacheDir, key[:2], "notes.txt"),
+		filepath.Join(cacheDir, key[:2], "deep", key+".lz4"),
+		filepath.Join(cacheDir, "zz", key+".lz4"),
+		filepath.Join(cacheDir, "ff", strings.Repeat("0", 64)+".lz4"),
+		filepath.Join(cacheDir, key[:2], strings.ToUpper(key)+".lz4"),
+	}
+	for _, path := range append(removed, kept...) {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte("x"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := PruneCache(&utils.Args{CacheDir: cacheDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range removed {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s wasn't removed", path)
+		}
+	}
+	for _, path := range kept {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s was removed", path)
+		}
+	}
+}
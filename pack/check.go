@@ -0,0 +1,245 @@
+package pack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"main/unpack"
+	"main/utils"
+	"main/verify"
+	"os"
+	"sync"
+)
+
+// Hash of the data stored for entry in f, decompressed if it's
+// compressed.
+func entryHash(f *os.File, header *unpack.Header, entry *unpack.FileEntry) (string, error) {
+	src := io.NewSectionReader(f, int64(header.BaseOffset+entry.DataOffset), int64(entry.CompSize))
+	if !entry.IsCompressed {
+		return hashReader(src)
+	}
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(unpack.DecompressStream(src, w))
+	}()
+	hash, err := hashReader(r)
+	r.Close()
+	return hash, err
+}
+
+// Hash file's data should have once written. Files copied from a source
+// packfile are compared as stored, so the hash is of those bytes.
+func sourceHash(file *File) (string, error) {
+	if file.SourcePath == "" {
+		if file.Hash != "" {
+			return file.Hash, nil
+		}
+		return hashFile(file.FullPath)
+	}
+	f, err := os.Open(file.SourcePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return hashReader(io.NewSectionReader(f, file.SourceOffset, int64(storedSize(file))))
+}
+
+func checkEntry(f *os.File, header *unpack.Header, entry *unpack.FileEntry, file *File) (string, error) {
+	want, err := sourceHash(file)
+	if err != nil {
+		return "", err
+	}
+	var got string
+	if file.SourcePath != "" {
+		got, err = hashReader(io.NewSectionReader(
+			f, int64(header.BaseOffset+entry.DataOffset), int64(entry.CompSize)))
+	} else {
+		got, err = entryHash(f, header, entry)
+	}
+	if err != nil {
+		return "", err
+	}
+	if got != want {
+		return fmt.Sprintf("%s\\%s doesn't match its source.", entry.Directory, entry.Name), nil
+	}
+	return "", nil
+}
+
+// Re-reads the packfile written to f and checks it against the header
+// and dirs it was laid out from. The header and entry table must be what
+// was intended, and each entry's data must match its source once
+// decompressed.
+func checkOutput(f *os.File, want *unpack.Header, dirs *Dirs, threads int) error {
+	fmt.Println("Verifying output...")
+	problems, err := verify.Check(f)
+	if err != nil {
+		return err
+	}
+	header, entries, err := unpack.Parse(f)
+	if err != nil {
+		return err
+	}
+	if *header != *want {
+		problems = append(problems, "Header doesn't have the fields it was written with.")
+	}
+	var (
+		files    []*File
+		dirNames []string
+	)
+	for _, dir := range dirs.Dirs {
+		for _, file := range dir.Files {
+			files = append(files, file)
+			dirNames = append(dirNames, dir.Name)
+		}
+	}
+	if len(entries) != len(files) {
+		problems = append(problems, fmt.Sprintf(
+			"Packfile has %d entries, %d were written.", len(entries), len(files)))
+	} else {
+		for idx, entry := range entries {
+			file := files[idx]
+			if entry.Name != file.Name || entry.Directory != dirNames[idx] {
+				problems = append(problems, fmt.Sprintf(
+					"Entry %d is %s\\%s, expected %s\\%s.",
+					idx, entry.Directory, entry.Name, dirNames[idx], file.Name))
+				continue
+			}
+			if entry.DataOffset != file.DataOffset || entry.UncompSize != file.Size ||
+				entry.CompSize != storedSize(file) || entry.IsCompressed != file.ShouldCompress ||
+				entry.Flags != file.Flag || entry.Alignment != file.EntryAlignment {
+				problems = append(problems, fmt.Sprintf(
+					"Entry %s\\%s doesn't have the offset, sizes or settings it was written with.",
+					entry.Directory, entry.Name))
+			}
+		}
+	}
+	if len(problems) == 0 {
+		problems, err = checkData(f, header, entries, files, threads)
+		if err != nil {
+			return err
+		}
+	}
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		return errors.New("Packed output failed verification.")
+	}
+	fmt.Println("Output verified.")
+	return nil
+}
+
+// Checks each entry's data against its source in a bounded worker pool.
+// Entries sharing data with an earlier one are checked through that one.
+func checkData(f *os.File, header *unpack.Header, entries []*unpack.FileEntry, files []*File, threads int) ([]string, error) {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		done     int
+		problems []string
+		firstErr error
+	)
+	ch := make(chan int)
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range ch {
+				problem, err := checkEntry(f, header, entries[idx], files[idx])
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				if problem != "" {
+					problems = append(problems, problem)
+				}
+				done++
+				fmt.Printf("\r%d of %d.", done, len(files))
+				mu.Unlock()
+			}
+		}()
+	}
+	ctx := utils.Context()
+	for idx, file := range files {
+		if file.SharesData {
+			mu.Lock()
+			done++
+			mu.Unlock()
+			continue
+		}
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed || ctx.Err() != nil {
+			break
+		}
+		ch <- idx
+	}
+	close(ch)
+	wg.Wait()
+	fmt.Println("")
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return problems, firstErr
+}
+
+// Offset of the header checksum.
+const checksumOffset = 0x08
+
+// Reports whether f holds the same bytes as the packfile at source,
+// leaving out the checksum. A missing source isn't an error, just not
+// the same.
+func sameAsSource(f *os.File, source string) (bool, error) {
+	src, err := os.Open(source)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return false, err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, err
+	}
+	if info.Size() != size {
+		return false, nil
+	}
+	a := make([]byte, copyBufferSize)
+	b := make([]byte, copyBufferSize)
+	for pos := int64(0); pos < size; pos += copyBufferSize {
+		n := int64(copyBufferSize)
+		if size-pos < n {
+			n = size - pos
+		}
+		_, err = f.ReadAt(a[:n], pos)
+		if err != nil {
+			return false, err
+		}
+		_, err = src.ReadAt(b[:n], pos)
+		if err != nil {
+			return false, err
+		}
+		if pos == 0 {
+			copy(b[checksumOffset:checksumOffset+4], a[checksumOffset:checksumOffset+4])
+		}
+		if !bytes.Equal(a[:n], b[:n]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func writeChecksum(f *os.File, checksum uint32) error {
+	_, err := f.Seek(checksumOffset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	return utils.WriteUint32(f, checksum)
+}
@@ -0,0 +1,91 @@
+package pack
+
+import (
+	"errors"
+	"main/unpack"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Folders unpack extracts to and the in-pack directory prefix of each.
+var defaultRoots = []string{"sr5=", `ctg=..\ctg\`}
+
+func parseRoot(value string) (*Root, error) {
+	idx := strings.Index(value, "=")
+	if idx == -1 {
+		return nil, errors.New("Root must be in folder=prefix form: " + value)
+	}
+	folder := strings.Trim(filepath.ToSlash(value[:idx]), "/")
+	if folder == "" || folder == "." {
+		return nil, errors.New("Root folder can't be empty: " + value)
+	}
+	return &Root{
+		Folder: strings.ToLower(folder),
+		Prefix: value[idx+1:],
+	}, nil
+}
+
+// Builds the mapping from on-disk paths to in-pack directories. Manifest
+// entries take priority over roots.
+func NewMapping(roots []string, manifest *unpack.Manifest) (*Mapping, error) {
+	if len(roots) == 0 {
+		roots = defaultRoots
+	}
+	mapping := &Mapping{
+		paths: map[string]*unpack.ManifestEntry{},
+		dirs:  map[string]string{},
+	}
+	for _, value := range roots {
+		root, err := parseRoot(value)
+		if err != nil {
+			return nil, err
+		}
+		mapping.Roots = append(mapping.Roots, root)
+	}
+	if manifest != nil {
+		for _, entry := range manifest.Entries {
+			mapping.paths[strings.ToLower(entry.Path)] = entry
+			mapping.dirs[strings.ToLower(GameDir(entry.Directory))] = GameDir(entry.Directory)
+		}
+	}
+	return mapping, nil
+}
+
+// Converts dir, a directory from disk, to the game's separators.
+// Packfiles always use backslashes, whatever OS they're built on, so
+// the same folders pack to the same names table on Linux and Windows.
+// Case is kept as it is on disk.
+func GameDir(dir string) string {
+	return strings.ReplaceAll(dir, "/", `\`)
+}
+
+// In-pack directory for dir, spelled as in the manifest if it has one
+// that only differs in case.
+func (mapping *Mapping) gameDir(dir string) string {
+	dir = GameDir(dir)
+	if spelled, ok := mapping.dirs[strings.ToLower(dir)]; ok {
+		return spelled
+	}
+	return dir
+}
+
+// Maps rel, a slash separated path relative to the input folder, to its
+// in-pack directory and name. ok is false if nothing maps it.
+func (mapping *Mapping) InPack(rel string) (string, string, bool) {
+	key := strings.ToLower(rel)
+	if entry, ok := mapping.paths[key]; ok {
+		return GameDir(entry.Directory), entry.Name, true
+	}
+	for _, root := range mapping.Roots {
+		if !strings.HasPrefix(key, root.Folder+"/") {
+			continue
+		}
+		relDir := path.Dir(rel[len(root.Folder)+1:])
+		if relDir == "." {
+			return mapping.gameDir(strings.TrimRight(root.Prefix, `\/`)), path.Base(rel), true
+		}
+		return mapping.gameDir(root.Prefix + relDir), path.Base(rel), true
+	}
+	return "", "", false
+}
@@ -0,0 +1,110 @@
+package pack
+
+import (
+	"bytes"
+	"main/unpack"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGameDirRoundTrip(t *testing.T) {
+	tests := []struct {
+		dir  string
+		game string
+	}{
+		{`data`, `data`},
+		{`Data`, `Data`},
+		{`..\ctg\data`, `..\ctg\data`},
+		{`../ctg/data`, `..\ctg\data`},
+		{`..\CTG/Data\Mods`, `..\CTG\Data\Mods`},
+		{`../Ctg\data/mods`, `..\Ctg\data\mods`},
+	}
+	for _, test := range tests {
+		game := GameDir(test.dir)
+		if game != test.game {
+			t.Errorf("GameDir(%q) = %q, want %q", test.dir, game, test.game)
+		}
+		local := unpack.LocalDir(game)
+		want := filepath.FromSlash(strings.ReplaceAll(test.game, `\`, "/"))
+		if local != want {
+			t.Errorf("LocalDir(%q) = %q, want %q", game, local, want)
+		}
+		if back := GameDir(filepath.ToSlash(local)); back != test.game {
+			t.Errorf("GameDir(LocalDir(%q)) = %q", game, back)
+		}
+	}
+}
+
+// Lays out the packfile index the files at rels map to, as pack does.
+func indexBytes(t *testing.T, rels []string) ([]byte, []byte) {
+	mapping, err := NewMapping(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := &Dirs{}
+	for idx, rel := range rels {
+		dir, name, ok := mapping.InPack(rel)
+		if !ok {
+			t.Fatalf("%s isn't mapped", rel)
+		}
+		Add(dirs, dir, &File{Name: name, Size: uint64(idx + 1), Alignment: 1})
+		dirs.FileTotal++
+	}
+	header, nameTable := layout(dirs, false)
+	header.Timestamp = 1
+	var buf bytes.Buffer
+	err = writeIndex(&buf, header, dirs, nameTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), nameTable
+}
+
+// Paths as walked on Linux, and the same files on Windows, relative
+// paths made with filepath.ToSlash there.
+func TestIndexSameOnLinuxAndWindows(t *testing.T) {
+	linux := []string{
+		"sr5/data/a.txt",
+		"sr5/data/mods/b.lua",
+		"sr5/top.txt",
+		"ctg/data/c.dds",
+	}
+	var windows []string
+	for _, rel := range []string{
+		`sr5\data\a.txt`,
+		`sr5\data\mods\b.lua`,
+		`sr5\top.txt`,
+		`ctg\data\c.dds`,
+	} {
+		windows = append(windows, strings.ReplaceAll(rel, `\`, "/"))
+	}
+	linuxIndex, nameTable := indexBytes(t, linux)
+	windowsIndex, _ := indexBytes(t, windows)
+	if !bytes.Equal(linuxIndex, windowsIndex) {
+		t.Errorf("index differs:\nlinux   %q\nwindows %q", linuxIndex, windowsIndex)
+	}
+	want := "data\x00a.txt\x00" +
+		"data\\mods\x00b.lua\x00" +
+		"\x00top.txt\x00" +
+		"..\\ctg\\data\x00c.dds\x00"
+	if string(nameTable) != want {
+		t.Errorf("names table\n got %q\nwant %q", nameTable, want)
+	}
+}
+
+func TestManifestDirCase(t *testing.T) {
+	manifest := &unpack.Manifest{Entries: []*unpack.ManifestEntry{
+		{Path: "sr5/Data/a.txt", Directory: `Data`, Name: "a.txt"},
+	}}
+	mapping, err := NewMapping(nil, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rel := range []string{"sr5/data/a.txt", "SR5/DATA/new.txt"} {
+		dir, _, ok := mapping.InPack(rel)
+		if !ok || dir != "Data" {
+			t.Errorf("InPack(%q) = %q, %t, want the manifest's Data", rel, dir, ok)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package pack
+
+import (
+	"main/unpack"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	OrderManifest  = "manifest"
+	OrderLexical   = "lexical"
+	OrderExtension = "extension"
+	OrderSize      = "size"
+)
+
+var orders = []string{OrderManifest, OrderLexical, OrderExtension, OrderSize}
+
+// Case and separator insensitive key, so the order doesn't depend on
+// the OS the files were walked on.
+func sortKey(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, `\`, "/"))
+}
+
+func lessName(a, b string) bool {
+	keyA, keyB := sortKey(a), sortKey(b)
+	if keyA != keyB {
+		return keyA < keyB
+	}
+	return a < b
+}
+
+func manifestKey(dir, name string) string {
+	return sortKey(dir) + "/" + sortKey(name)
+}
+
+// Sorts directories and their files into order. Files missing from the
+// manifest go after the ones in it, in lexical order.
+func SortDirs(dirs *Dirs, order string, manifest *unpack.Manifest) {
+	positions := map[string]int{}
+	dirPositions := map[string]int{}
+	if order == OrderManifest && manifest != nil {
+		for idx, entry := range manifest.Entries {
+			positions[manifestKey(entry.Directory, entry.Name)] = idx
+			dirKey := sortKey(entry.Directory)
+			if _, ok := dirPositions[dirKey]; !ok {
+				dirPositions[dirKey] = idx
+			}
+		}
+	}
+	// Reports whether keyA comes first, and false for ok if neither
+	// key has a position.
+	lessPosition := func(positions map[string]int, keyA, keyB string) (bool, bool) {
+		idxA, okA := positions[keyA]
+		idxB, okB := positions[keyB]
+		switch {
+		case okA && okB:
+			return idxA < idxB, true
+		case okA != okB:
+			return okA, true
+		}
+		return false, false
+	}
+	for _, dir := range dirs.Dirs {
+		files := dir.Files
+		dirName := dir.Name
+		sort.SliceStable(files, func(i, j int) bool {
+			a, b := files[i], files[j]
+			switch order {
+			case OrderManifest:
+				less, ok := lessPosition(
+					positions, manifestKey(dirName, a.Name), manifestKey(dirName, b.Name))
+				if ok {
+					return less
+				}
+			case OrderExtension:
+				extA, extB := sortKey(filepath.Ext(a.Name)), sortKey(filepath.Ext(b.Name))
+				if extA != extB {
+					return extA < extB
+				}
+			case OrderSize:
+				if a.Size != b.Size {
+					return a.Size > b.Size
+				}
+			}
+			return lessName(a.Name, b.Name)
+		})
+	}
+	sort.SliceStable(dirs.Dirs, func(i, j int) bool {
+		a, b := dirs.Dirs[i], dirs.Dirs[j]
+		if order == OrderManifest {
+			less, ok := lessPosition(dirPositions, sortKey(a.Name), sortKey(b.Name))
+			if ok {
+				return less
+			}
+		}
+		return lessName(a.Name, b.Name)
+	})
+}
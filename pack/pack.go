@@ -2,33 +2,37 @@ package pack
 
 import (
 	"bytes"
-	"encoding/binary"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"main/unpack"
 	"main/utils"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
-var (
-	null           = []byte{'\x00'}
-	pathSep        = fmt.Sprintf("%c", os.PathSeparator)
-	defaultOutPath = "SRTools_packed.vpp_pc"
+const (
+	// Entries start right after the fixed-size header.
+	dirEntriesOffset = 0x78
+	dirEntrySize     = 48
+	// With --pad, the data block starts on this boundary and entry
+	// offsets are aligned relative to it. Neither is confirmed against
+	// stock packfiles yet; the commented out padding the original writer
+	// had crashed the game, so it's off by default.
+	dataAlign = 2048
+	// Data is streamed through a buffer this size, whatever the entry size.
+	copyBufferSize = 1 << 20
 )
 
 var (
-	extensions = []string{
-		".bk2", ".bik", ".str2_pc", ".strh_pc", ".cvbm_pc", ".gvbm_pc",
-		".vpp_pc", ".vpkg", ".ttf", ".xml", ".ridv_pc", ".lua",
-		".wem_pad", ".hkcmp_64m", ".refl_xbox3_gdk", ".refl_pc",
-		".sr2_h", ".bin", ".txt", ".vint_proj",
-	}
-	extensionsTwo = []string{".fxo_dx11_pc", ".fxo_dx12_pc", ".fxo_vk_pc"}
-	//extensionsTwo   = []string{".sr2_h"}
-	extensionsThree = []string{".bk2"}
+	null           = []byte{'\x00'}
+	defaultOutPath = "SRTools_packed.vpp_pc"
 )
 
 func processArgs(args *utils.Args) (*utils.Args, error) {
@@ -38,6 +42,26 @@ func processArgs(args *utils.Args) (*utils.Args, error) {
 	if !(strings.HasSuffix(args.OutPath, ".vpp_pc") || strings.HasSuffix(args.OutPath, ".str2_pc")) {
 		return nil, errors.New("Invalid output file file extension.")
 	}
+	if !(args.Threads >= 1 && args.Threads <= 50) {
+		return nil, errors.New("Max threads must be between 1 and 50.")
+	}
+	if !(args.MinSaving >= 0 && args.MinSaving <= 100) {
+		return nil, errors.New("Minimum saving must be between 0 and 100.")
+	}
+	args.Order = strings.ToLower(args.Order)
+	if args.Order == "" {
+		if args.Manifest != "" {
+			args.Order = OrderManifest
+		} else {
+			args.Order = OrderLexical
+		}
+	}
+	if !contains(orders, args.Order) {
+		return nil, errors.New("Order must be one of: " + strings.Join(orders, ", ") + ".")
+	}
+	if args.Order == OrderManifest && args.Manifest == "" {
+		return nil, errors.New("Manifest order needs --manifest.")
+	}
 	return args, nil
 }
 
@@ -50,180 +74,355 @@ func contains(arr []string, v string) bool {
 	return false
 }
 
-func add(dirs *Dirs, path string, file *File) {
-	for i, dir := range dirs.Dirs {
-		if dir.Name == path {
-			dirs.Dirs[i].Files = append(dirs.Dirs[i].Files, file)
-			return
+// Appends file to the directory named path, creating it if needed.
+// path is converted to the game's separators; its case is kept, so
+// directories from a stock packfile stay as they are.
+func Add(dirs *Dirs, path string, file *File) {
+	path = GameDir(path)
+	if dirs.index == nil {
+		dirs.index = map[string]*Dir{}
+		for _, dir := range dirs.Dirs {
+			dirs.index[dir.Name] = dir
 		}
 	}
-	dir := &Dir{
+	dir, ok := dirs.index[path]
+	if ok {
+		dir.Files = append(dir.Files, file)
+		return
+	}
+	dir = &Dir{
 		Name:  path,
 		Files: []*File{file},
 	}
 	dirs.Dirs = append(dirs.Dirs, dir)
+	dirs.index[path] = dir
 }
 
-func hasExtension(fname string, extensions []string) bool {
-	for _, ext := range extensions {
-		if strings.HasSuffix(fname, ext) {
-			return true
-		}
+func hashReader(r io.Reader) (string, error) {
+	hash := sha256.New()
+	_, err := io.Copy(hash, r)
+	if err != nil {
+		return "", err
 	}
-	return false
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-func getAlign(fname string) int16 {
-	var align int16
-	switch {
-	case hasExtension(fname, extensionsTwo):
-		align = 16
-	case hasExtension(fname, extensionsThree):
-		align = 2048
-	default:
-		align = 1
-	}
-	return align
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return hashReader(f)
+}
+
+// Hashes file and, if an earlier file has the same content and stored
+// settings, marks it as a duplicate of that one.
+func dedupe(seen map[string]*File, file *File) error {
+	hash, err := hashFile(file.FullPath)
+	if err != nil {
+		return err
+	}
+	file.Hash = hash
+	key := fmt.Sprintf(
+		"%s/%t/%d/%d/%d", hash, file.ShouldCompress, file.Flag, file.Alignment, file.Level)
+	if orig, ok := seen[key]; ok {
+		file.DuplicateOf = orig
+	} else {
+		seen[key] = file
+	}
+	return nil
 }
 
-func populateDirs(packFolder, tempPath string, compressAll, noCompression bool) (*Dirs, error) {
-	var fileTotal int
+// Walks packFolder and maps each file into its in-pack directory.
+// Returns the paths of files nothing maps, which are left out.
+func populateDirs(packFolder string, policy *Policy, mapping *Mapping, dedupeFiles bool) (*Dirs, []string, error) {
+	var (
+		fileTotal int
+		skipped   []string
+	)
 	dirs := &Dirs{
 		Dirs: []*Dir{},
 	}
-	if !noCompression {
-		fmt.Println("Compression is enabled, this may take a while for large packfiles.")
-		fmt.Println("Compressing files...")
-	}
-	err := filepath.Walk(packFolder, func(path string, f os.FileInfo, err error) error {
-		if path == packFolder {
+	seen := map[string]*File{}
+	err := filepath.Walk(packFolder, func(fullPath string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.IsDir() {
 			return nil
 		}
-		if !f.IsDir() {
-			var (
-				flag           int16
-				align          int16
-				shouldCompress bool
-			)
-			folder := "sr5"
-			idx := strings.Index(path, pathSep+"data"+pathSep)
-			if idx == -1 {
-				return nil
-			}
-			path = path[idx+1:]
-			path = filepath.Dir(path)
-			if strings.HasPrefix(path, "data"+pathSep+"engine") {
-				path = `..\ctg\` + path
-				folder = "ctg"
-			}
-			fname := f.Name()
-			if noCompression {
-				shouldCompress = false
-			} else if compressAll {
-				shouldCompress = true
-			} else {
-				// ".bnk_pad"
-				shouldCompress = !hasExtension(fname, extensions)
-			}
-			if shouldCompress {
-				flag = 1
-				if noCompression {
-					align = 1
-				} else {
-					align = getAlign(fname)
-				}
-			} else {
-				align = getAlign(fname)
-			}
-
-			file := &File{
-				Name:           fname,
-				Size:           f.Size(),
-				FullPath:       filepath.Join(packFolder, folder, path, fname),
-				ShouldCompress: shouldCompress,
-				Flag:           flag,
-				Alignment:      align,
-			}
-			if shouldCompress {
-				compPath, compSize, err := compress(file.FullPath, tempPath)
-				if err != nil {
-					return err
-				}
-				file.CompressedPath = compPath
-				file.CompressedSize = compSize
+		rel, err := filepath.Rel(packFolder, fullPath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !strings.Contains(rel, "/") && strings.HasSuffix(rel, ".manifest.json") {
+			return nil
+		}
+		dir, fname, ok := mapping.InPack(rel)
+		if !ok {
+			skipped = append(skipped, rel)
+			return nil
+		}
+		file := policy.NewFile(dir, fname, fullPath, uint64(f.Size()))
+		if dedupeFiles {
+			err = dedupe(seen, file)
+			if err != nil {
+				return err
 			}
-			add(dirs, path, file)
-			fileTotal++
 		}
+		Add(dirs, dir, file)
+		fileTotal++
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	dirs.FileTotal = fileTotal
-	return dirs, nil
-}
-
-func writeUint16(f *os.File, value int16) error {
-	buf := make([]byte, 2)
-	binary.LittleEndian.PutUint16(buf, uint16(value))
-	_, err := f.Write(buf)
-	return err
-}
-
-func writeUint32(f *os.File, value int32) error {
-	buf := make([]byte, 4)
-	binary.LittleEndian.PutUint32(buf, uint32(value))
-	_, err := f.Write(buf)
-	return err
-}
-
-func writeUint64(f *os.File, value int64) error {
-	buf := make([]byte, 8)
-	binary.LittleEndian.PutUint64(buf, uint64(value))
-	_, err := f.Write(buf)
-	return err
+	return dirs, skipped, nil
 }
 
 func getCurrentPos(f *os.File) (int64, error) {
 	return f.Seek(0, io.SeekCurrent)
 }
 
-func getPackFolder(path string) string {
-	lastIdx := strings.LastIndex(path, pathSep)
-	if lastIdx == -1 {
-		return path
-	} else {
-		return path[lastIdx+len(pathSep):]
+// Compresses path to outPath with lz4 and returns the compressed size.
+func runLz4(path, outPath string, level int) (uint64, error) {
+	var errBuffer bytes.Buffer
+	args := append(lz4Args(level), path, outPath)
+	cmd := utils.Command("lz4", args...)
+	cmd.Stderr = &errBuffer
+	err := utils.RunCommand(cmd)
+	if err != nil {
+		errString := err.Error() + "\n" + errBuffer.String()
+		return 0, errors.New(errString)
+	}
+	f, err := os.Stat(outPath)
+	if err != nil {
+		return 0, err
 	}
+	return uint64(f.Size()), nil
 }
 
-func compress(path, tempPath string) (string, int64, error) {
+func compress(path, tempPath string, level int) (string, uint64, error) {
 	outPath := filepath.Join(tempPath, path)
 	err := os.MkdirAll(filepath.Dir(outPath), 0755)
 	if err != nil {
-		return "", -1, err
+		return "", 0, err
+	}
+	compSize, err := runLz4(path, outPath, level)
+	if err != nil {
+		return "", 0, err
+	}
+	return outPath, compSize, nil
+}
+
+// Whether compressing size bytes down to compSize saves at least
+// minSaving percent.
+func worthCompressing(size, compSize uint64, minSaving int) bool {
+	if compSize >= size {
+		return false
+	}
+	saving := float64(size-compSize) * 100 / float64(size)
+	return saving >= float64(minSaving)
+}
+
+// Compresses in a bounded worker pool. Results are stored on each file,
+// so the entry order stays that of dirs. Files copied from a source
+// packfile are already compressed and are skipped, as are duplicates,
+// which share the data of their original. Files that don't save
+// args.MinSaving percent are stored uncompressed. With args.Cache,
+// compressed output is reused from and kept in the cache.
+func Compress(dirs *Dirs, tempPath string, args *utils.Args) error {
+	var files []*File
+	for _, dir := range dirs.Dirs {
+		for _, file := range dir.Files {
+			if file.ShouldCompress && file.SourcePath == "" && file.DuplicateOf == nil {
+				files = append(files, file)
+			}
+		}
+	}
+	total := len(files)
+	if total == 0 {
+		return nil
 	}
 	var (
-		errBuffer bytes.Buffer
-		args      = []string{"-9", "-B5D", path, outPath}
+		cacheDir string
+		err      error
 	)
-	cmd := exec.Command("lz4", args...)
-	cmd.Stderr = &errBuffer
-	err = cmd.Run()
-	if err != nil {
-		errString := err.Error() + "\n" + errBuffer.String()
-		return "", -1, errors.New(errString)
+	if args.Cache {
+		cacheDir, err = CacheDir(args.CacheDir)
+		if err != nil {
+			return err
+		}
 	}
-	f, err := os.Stat(outPath)
+	fmt.Println("Compression is enabled, this may take a while for large packfiles.")
+	fmt.Println("Compressing files...")
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		done     int
+		hits     int
+		firstErr error
+	)
+	ch := make(chan *File)
+	for i := 0; i < args.Threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range ch {
+				var (
+					compPath string
+					compSize uint64
+					hit      bool
+					err      error
+				)
+				if cacheDir != "" {
+					compPath, compSize, hit, err = compressCached(file, cacheDir)
+				} else {
+					compPath, compSize, err = compress(file.FullPath, tempPath, file.Level)
+				}
+				if err == nil && !worthCompressing(file.Size, compSize, args.MinSaving) {
+					// Cached output stays, so the next run doesn't try again.
+					if cacheDir == "" {
+						err = os.Remove(compPath)
+					}
+					file.ShouldCompress = false
+					file.Flag &^= 1
+				} else if err == nil {
+					file.CompressedPath = compPath
+					file.CompressedSize = compSize
+					file.Cached = cacheDir != ""
+				}
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				if hit {
+					hits++
+				}
+				done++
+				fmt.Printf("\r%d of %d.", done, total)
+				mu.Unlock()
+			}
+		}()
+	}
+	ctx := utils.Context()
+	for _, file := range files {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed || ctx.Err() != nil {
+			break
+		}
+		ch <- file
+	}
+	close(ch)
+	wg.Wait()
+	fmt.Println("")
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if cacheDir != "" && firstErr == nil {
+		fmt.Printf("Reused %d of %d compressed file(s) from the cache.\n", hits, total)
+	}
+	return firstErr
+}
+
+// Size of the bytes stored in the packfile for file.
+func storedSize(file *File) uint64 {
+	if file.ShouldCompress {
+		return file.CompressedSize
+	}
+	return file.Size
+}
+
+// Streams file's stored bytes into f through buf, from its compressed
+// temp file, the file itself or its source packfile. Open sources are
+// kept in sources for reuse.
+func writeData(f *os.File, buf []byte, sources map[string]*os.File, file *File) error {
+	size := storedSize(file)
+	var src io.Reader
+	if file.SourcePath != "" {
+		packfile, ok := sources[file.SourcePath]
+		if !ok {
+			var err error
+			packfile, err = os.Open(file.SourcePath)
+			if err != nil {
+				return err
+			}
+			sources[file.SourcePath] = packfile
+		}
+		src = io.NewSectionReader(packfile, file.SourceOffset, int64(size))
+	} else {
+		path := file.FullPath
+		if file.ShouldCompress {
+			path = file.CompressedPath
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		src = in
+	}
+	written, err := io.CopyBuffer(f, src, buf)
 	if err != nil {
-		return "", -1, err
+		return err
+	}
+	if uint64(written) != size {
+		return errors.New("Size of " + file.Name + " changed while packing.")
+	}
+	return nil
+}
+
+// Prints how well each file extension compressed, by stored size
+// against original size.
+func PrintRatios(dirs *Dirs) {
+	stats := map[string]*ExtStats{}
+	for _, dir := range dirs.Dirs {
+		for _, file := range dir.Files {
+			ext := strings.ToLower(filepath.Ext(file.Name))
+			if ext == "" {
+				ext = "(none)"
+			}
+			stat, ok := stats[ext]
+			if !ok {
+				stat = &ExtStats{}
+				stats[ext] = stat
+			}
+			stat.Files++
+			if file.ShouldCompress {
+				stat.Compressed++
+			}
+			stat.Size += file.Size
+			// Shared data is only stored once.
+			if !file.SharesData {
+				stat.Stored += storedSize(file)
+			}
+		}
+	}
+	var exts []string
+	for ext := range stats {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	fmt.Println("Compression by extension:")
+	for _, ext := range exts {
+		stat := stats[ext]
+		ratio := 100.0
+		if stat.Size > 0 {
+			ratio = float64(stat.Stored) * 100 / float64(stat.Size)
+		}
+		fmt.Printf(
+			"%s: %d of %d compressed, %d -> %d bytes (%.1f%%)\n",
+			ext, stat.Compressed, stat.Files, stat.Size, stat.Stored, ratio)
 	}
-	return outPath, f.Size(), nil
 }
 
 func getTempPath() (string, error) {
-	return os.MkdirTemp(os.TempDir(), "")
+	return utils.MkdirTemp()
 }
 
 // Clean up.
@@ -232,285 +431,366 @@ func Run(args *utils.Args) error {
 	if err != nil {
 		return err
 	}
-	outPath := args.OutPath
-	compressAll := strings.HasSuffix(outPath, ".str2_pc")
+	policy, err := LoadPolicy(args.Policy, args.OutPath, args.NoCompression)
+	if err != nil {
+		return err
+	}
 	tempPath, err := getTempPath()
 	if err != nil {
 		return err
 	}
 	defer os.RemoveAll(tempPath)
-	packFolder := getPackFolder(args.InPaths[0])
+	var manifest *unpack.Manifest
+	if args.Manifest != "" {
+		manifest, err = unpack.ReadManifest(args.Manifest)
+		if err != nil {
+			return err
+		}
+	}
+	mapping, err := NewMapping(args.Roots, manifest)
+	if err != nil {
+		return err
+	}
+	packFolder, err := filepath.Abs(args.InPaths[0])
+	if err != nil {
+		return err
+	}
 	fmt.Println("Populating paths...")
-	dirs, err := populateDirs(packFolder, tempPath, compressAll, args.NoCompression)
+	dirs, skipped, err := populateDirs(packFolder, policy, mapping, args.Dedupe)
+	if err != nil {
+		return err
+	}
+	if len(skipped) > 0 {
+		fmt.Printf("Skipped %d file(s) outside the mapped roots:\n", len(skipped))
+		for _, rel := range skipped {
+			fmt.Println(rel)
+		}
+	}
+	SortDirs(dirs, args.Order, manifest)
+	err = Compress(dirs, tempPath, args)
+	if err != nil {
+		return err
+	}
+	err = Write(dirs, args)
 	if err != nil {
 		return err
 	}
+	PrintRatios(dirs)
+	return nil
+}
+
+// Works out the names table, each file's data offset and the header
+// fields that follow from them. With pad, data is aligned as described
+// at dataAlign.
+func layout(dirs *Dirs, pad bool) (*unpack.Header, []byte) {
 	var (
 		nameTable      []byte
-		dataSize       int64
-		uncompDataSize int64
-		compDataSize   int64
+		dataSize       uint64
+		uncompDataSize uint64
 	)
-	fmt.Println("Building name and directory string table...")
-	for idx, dir := range dirs.Dirs {
-		dirs.Dirs[idx].NameOffset = int64(len(nameTable))
+	// Whichever file of a duplicate group comes first places the data.
+	placed := map[*File]uint64{}
+	for _, dir := range dirs.Dirs {
+		dir.NameOffset = uint64(len(nameTable))
 		nameTable = append(nameTable, []byte(dir.Name)...)
 		nameTable = append(nameTable, null...)
-		for fileIdx, file := range dir.Files {
-			dirs.Dirs[idx].Files[fileIdx].NameOffset = int64(len(nameTable))
+		for _, file := range dir.Files {
+			file.NameOffset = uint64(len(nameTable))
 			nameTable = append(nameTable, []byte(file.Name)...)
 			nameTable = append(nameTable, null...)
-			dirs.Dirs[idx].Files[fileIdx].DataOffset = dataSize
-			if file.ShouldCompress {
-				compDataSize += file.CompressedSize
-				dataSize += file.CompressedSize
-			} else {
-				compDataSize += file.Size
-				dataSize += file.Size
+			orig := file
+			if file.DuplicateOf != nil {
+				orig = file.DuplicateOf
+			}
+			file.EntryAlignment = 1
+			if pad {
+				file.EntryAlignment = file.Alignment
+			}
+			if offset, ok := placed[orig]; ok {
+				file.DataOffset = offset
+				file.SharesData = true
+				continue
 			}
-			uncompDataSize += file.Size
+			align := uint64(file.EntryAlignment)
+			dataSize = utils.AlignUp(dataSize, align)
+			file.DataOffset = dataSize
+			placed[orig] = dataSize
+			dataSize += storedSize(file)
+			uncompDataSize = utils.AlignUp(uncompDataSize, align) + file.Size
 		}
 	}
-	if args.NoCompression {
-		compDataSize += 21962
-	}
-	uncompDataSize += 21962
+	namesOffset := dirEntriesOffset + uint64(dirs.FileTotal)*dirEntrySize + uint64(len(dirs.Dirs))*8
+	baseOffset := namesOffset + uint64(len(nameTable))
+	if pad {
+		baseOffset = utils.AlignUp(baseOffset, dataAlign)
+	}
+	// The compressed data size is the data block as stored, padding
+	// included. The data size is the same block with every entry stored
+	// uncompressed, so the two match when nothing is compressed. Shared
+	// data counts once in both. Neither is confirmed against stock
+	// packfiles; verify --stock shows whether they agree.
+	header := &unpack.Header{
+		DirEntryCount: uint32(dirs.FileTotal),
+		DirCount:      uint32(len(dirs.Dirs)),
+		NamesOffset:   namesOffset,
+		NamesSize:     uint32(len(nameTable)),
+		PackSize:      baseOffset + dataSize,
+		DataSize:      uncompDataSize,
+		CompDataSize:  dataSize,
+		BaseOffset:    baseOffset,
+	}
+	return header, nameTable
+}
 
-	f, err := os.OpenFile(outPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	fmt.Println("Writing header...")
+// Writes everything before the data: the header, file entries,
+// directory name offsets, names table and any padding up to
+// header.BaseOffset.
+func writeIndex(w io.Writer, header *unpack.Header, dirs *Dirs, nameTable []byte) error {
 	// magic
-	_, err = f.Write([]byte{'\xCE', '\x0A', '\x89', '\x51'})
+	_, err := w.Write([]byte{'\xCE', '\x0A', '\x89', '\x51'})
 	if err != nil {
 		return err
 	}
 	// version
-	err = writeUint32(f, 17)
+	err = utils.WriteUint32(w, 17)
 	if err != nil {
 		return err
 	}
-	// crc
-	_, err = f.Write(bytes.Repeat(null, 4))
+	// crc. What it's computed over isn't known, so it's left 0 here;
+	// see Dirs.Source.
+	err = utils.WriteUint32(w, header.Checksum)
 	if err != nil {
 		return err
 	}
 	// flags
-	err = writeUint32(f, 20481)
+	err = utils.WriteUint32(w, 20481)
 	if err != nil {
 		return err
 	}
 	// file count
-	err = writeUint32(f, int32(dirs.FileTotal))
+	err = utils.WriteUint32(w, header.DirEntryCount)
 	if err != nil {
 		return err
 	}
 	// dir count
-	err = writeUint32(f, int32(len(dirs.Dirs)))
-	if err != nil {
-		return err
-	}
-	namesOffset, err := getCurrentPos(f)
+	err = utils.WriteUint32(w, header.DirCount)
 	if err != nil {
 		return err
 	}
-	// names offset
-	err = writeUint32(f, 0)
+	// names offset, from the first file entry
+	err = utils.WriteUint32(w, uint32(header.NamesOffset-dirEntriesOffset))
 	if err != nil {
 		return err
 	}
 	// names size
-	err = writeUint32(f, 0)
-	if err != nil {
-		return err
-	}
-	packSize, err := getCurrentPos(f)
+	err = utils.WriteUint32(w, header.NamesSize)
 	if err != nil {
 		return err
 	}
 	// pack size
-	err = writeUint64(f, 0)
+	err = utils.WriteUint64(w, header.PackSize)
 	if err != nil {
 		return err
 	}
 	// data size
-	err = writeUint64(f, uncompDataSize)
+	err = utils.WriteUint64(w, header.DataSize)
 	if err != nil {
 		return err
 	}
 	// compressed data size
-	err = writeUint64(f, compDataSize)
+	err = utils.WriteUint64(w, header.CompDataSize)
 	if err != nil {
 		return err
 	}
 	// epoch timestamp
-	err = writeUint64(f, 0)
-	if err != nil {
-		return err
-	}
-	dataOffsetBase, err := getCurrentPos(f)
+	err = utils.WriteUint64(w, header.Timestamp)
 	if err != nil {
 		return err
 	}
 	// data offset base
-	err = writeUint64(f, 0)
+	err = utils.WriteUint64(w, header.BaseOffset)
 	if err != nil {
 		return err
 	}
 	// reserved
-	_, err = f.Write(bytes.Repeat(null, 48))
+	_, err = w.Write(bytes.Repeat(null, 48))
 	if err != nil {
 		return err
 	}
-	fmt.Println("Writing file entries...")
 	for _, dir := range dirs.Dirs {
 		for _, file := range dir.Files {
-			err = writeUint64(f, file.NameOffset)
+			err = utils.WriteUint64(w, file.NameOffset)
 			if err != nil {
 				return err
 			}
-			err = writeUint64(f, dir.NameOffset)
+			err = utils.WriteUint64(w, dir.NameOffset)
 			if err != nil {
 				return err
 			}
-			err = writeUint64(f, file.DataOffset)
+			err = utils.WriteUint64(w, file.DataOffset)
 			if err != nil {
 				return err
 			}
-			err = writeUint64(f, file.Size)
+			err = utils.WriteUint64(w, file.Size)
 			if err != nil {
 				return err
 			}
 			if file.ShouldCompress {
-				err = writeUint64(f, file.CompressedSize)
+				err = utils.WriteUint64(w, file.CompressedSize)
 			} else {
-				_, err = f.Write(bytes.Repeat([]byte{'\xFF'}, 8))
+				_, err = w.Write(bytes.Repeat([]byte{'\xFF'}, 8))
 			}
 			if err != nil {
 				return err
 			}
-			err = writeUint16(f, file.Flag)
+			err = utils.WriteUint16(w, file.Flag)
 			if err != nil {
 				return err
 			}
-			err = writeUint16(f, file.Alignment)
+			err = utils.WriteUint16(w, file.EntryAlignment)
 			if err != nil {
 				return err
 			}
 			// 375
-			err = writeUint32(f, 375)
+			err = utils.WriteUint32(w, 375)
 			if err != nil {
 				return err
 			}
-			// _, err = f.Write(bytes.Repeat(null, 4))
-			// if err != nil {
-			// 	return err
-			// }
 		}
 	}
-	fmt.Println("Writing directory name offsets...")
 	for _, dir := range dirs.Dirs {
-		err = writeUint64(f, dir.NameOffset)
+		err = utils.WriteUint64(w, dir.NameOffset)
 		if err != nil {
 			return err
 		}
 	}
-	curPos, err := getCurrentPos(f)
-	if err != nil {
-		return err
-	}
-	_, err = f.Seek(namesOffset, io.SeekStart)
-	if err != nil {
-		return err
-	}
-	err = writeUint32(f, int32(curPos-120))
+	_, err = w.Write(nameTable)
 	if err != nil {
 		return err
 	}
-	err = writeUint32(f, int32(len(nameTable)))
-	if err != nil {
-		return err
+	pad := header.BaseOffset - (header.NamesOffset + uint64(header.NamesSize))
+	return utils.WriteNull(w, int(pad))
+}
+
+// Writes dirs to args.OutPath as a packfile. Compressed files must
+// already have been through Compress.
+func Write(dirs *Dirs, args *utils.Args) error {
+	// Duplicates take the stored form of the file they share data with.
+	for _, dir := range dirs.Dirs {
+		for _, file := range dir.Files {
+			if file.DuplicateOf != nil {
+				orig := file.DuplicateOf
+				file.ShouldCompress = orig.ShouldCompress
+				file.CompressedPath = orig.CompressedPath
+				file.CompressedSize = orig.CompressedSize
+				file.Cached = orig.Cached
+				file.Flag = orig.Flag
+			}
+		}
 	}
-	_, err = f.Seek(curPos, io.SeekStart)
-	if err != nil {
-		return err
+	fmt.Println("Building name and directory string table...")
+	header, nameTable := layout(dirs, args.Pad)
+	var (
+		sharedFiles int
+		savedBytes  uint64
+	)
+	for _, dir := range dirs.Dirs {
+		for _, file := range dir.Files {
+			if file.SharesData {
+				sharedFiles++
+				savedBytes += storedSize(file)
+			}
+		}
 	}
-	_, err = f.Write(nameTable)
-	if err != nil {
-		return err
+	if sharedFiles > 0 {
+		fmt.Printf("Deduplicated %d file(s), saving %d bytes.\n", sharedFiles, savedBytes)
 	}
-	curPos, err = getCurrentPos(f)
-	if err != nil {
-		return err
+	header.Timestamp = uint64(time.Now().Unix())
+	if args.Timestamp != nil {
+		header.Timestamp = uint64(*args.Timestamp)
 	}
-	_, err = f.Seek(dataOffsetBase, io.SeekStart)
+	out, err := utils.CreateAtomic(args.OutPath)
 	if err != nil {
 		return err
 	}
-	err = writeUint32(f, int32(curPos))
-	if err != nil {
-		return err
-	}
-	_, err = f.Seek(curPos, io.SeekStart)
+	defer out.Abort()
+	f := out.File
+	fmt.Println("Writing header, file entries and names...")
+	err = writeIndex(f, header, dirs, nameTable)
 	if err != nil {
 		return err
 	}
+	buf := make([]byte, copyBufferSize)
+	sources := map[string]*os.File{}
+	defer func() {
+		for _, src := range sources {
+			src.Close()
+		}
+	}()
 	fmt.Println("Writing files...")
+	ctx := utils.Context()
 	i := 1
 	for _, dir := range dirs.Dirs {
 		for _, file := range dir.Files {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			fmt.Printf("\r%d of %d.", i, dirs.FileTotal)
-			var path string
-			if file.ShouldCompress {
-				path = file.CompressedPath
-			} else {
-				path = file.FullPath
+			if file.SharesData {
+				i++
+				continue
 			}
-			data, err := os.ReadFile(path)
+			curPos, err := getCurrentPos(f)
 			if err != nil {
 				return err
 			}
-			// Crashes game.
-			// align := int64(file.Alignment)
-			// if align == 2048 || i == 1 {
-			// 	if i == 1 {
-			// 		align = 2048
-			// 	}
-			// 	fmt.Println(file.Name)
-			// 	curPos, err := getCurrentPos(f)
-			// 	if err != nil {
-			// 		return err
-			// 	}
-			// 	pos := (curPos + align - 1) / align * align
-			// 	_, err = f.Seek(pos, io.SeekStart)
-			// 	if err != nil {
-			// 		return err
-			// 	}
-			// }
-			_, err = f.Write(data)
+			pad := int64(header.BaseOffset+file.DataOffset) - curPos
+			if pad < 0 {
+				return errors.New("Data offset is behind the write position: " + file.Name)
+			}
+			err = utils.WriteNull(f, int(pad))
 			if err != nil {
 				return err
 			}
-			if file.ShouldCompress {
-				err = os.Remove(path)
+			err = writeData(f, buf, sources, file)
+			if err != nil {
+				return err
+			}
+			if file.ShouldCompress && file.SourcePath == "" && !file.Cached {
+				err = os.Remove(file.CompressedPath)
 				if err != nil {
-					fmt.Println("Failed to delete compressed file:", path)
+					fmt.Println("Failed to delete compressed file:", file.CompressedPath)
 				}
 			}
 			i++
 		}
 	}
 	fmt.Println("")
-	curPos, err = getCurrentPos(f)
+	curPos, err := getCurrentPos(f)
 	if err != nil {
 		return err
 	}
-	_, err = f.Seek(packSize, io.SeekStart)
-	if err != nil {
-		return err
+	if uint64(curPos) != header.PackSize {
+		return errors.New("Packfile didn't come out the size it was laid out to be.")
+	}
+	if !args.NoVerify {
+		err = checkOutput(f, header, dirs, args.Threads)
+		if err != nil {
+			return err
+		}
+	}
+	if dirs.Source != "" {
+		same, err := sameAsSource(f, dirs.Source)
+		if err != nil {
+			return err
+		}
+		if same {
+			fmt.Println("Output is the same as the original packfile, keeping its checksum.")
+			err = writeChecksum(f, dirs.Checksum)
+			if err != nil {
+				return err
+			}
+		}
 	}
-	err = writeUint32(f, int32(curPos))
-	return err
+	return out.Commit()
 }
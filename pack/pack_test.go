@@ -0,0 +1,88 @@
+package pack
+
+import (
+	"main/unpack"
+	"main/verify"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Lays out and writes the index of a packfile whose data runs past
+// 4 GB, makes it that size with a sparse Truncate, and checks every
+// 64-bit header and entry field parses back as written.
+func TestWriteIndexOver4GB(t *testing.T) {
+	for _, pad := range []bool{false, true} {
+		dirs := &Dirs{}
+		files := []*File{
+			{Name: "big.bk2", Size: 5<<30 + 3, Alignment: 2048},
+			{Name: "huge.dat", Size: 6 << 30, CompressedSize: 4<<30 + 77, ShouldCompress: true, Flag: 1, Alignment: 16},
+			{Name: "after.txt", Size: 10, Alignment: 1},
+		}
+		Add(dirs, "data", files[0])
+		Add(dirs, `..\ctg\data`, files[1])
+		Add(dirs, "data", files[2])
+		dirs.FileTotal = len(files)
+		want, nameTable := layout(dirs, pad)
+		want.Timestamp = 1<<32 + 5
+
+		f, err := os.Create(filepath.Join(t.TempDir(), "big.vpp_pc"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		err = writeIndex(f, want, dirs, nameTable)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = f.Truncate(int64(want.PackSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want.PackSize <= 1<<32 || want.DataSize <= 1<<32 || want.CompDataSize <= 1<<32 {
+			t.Fatalf("sizes don't pass 4 GB: %+v", want)
+		}
+
+		got, entries, err := unpack.Parse(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got != *want {
+			t.Errorf("pad %t: header\n got %+v\nwant %+v", pad, got, want)
+		}
+		if len(entries) != 3 {
+			t.Fatalf("pad %t: got %d entries, want 3", pad, len(entries))
+		}
+		var idx int
+		for _, dir := range dirs.Dirs {
+			for _, file := range dir.Files {
+				entry := entries[idx]
+				idx++
+				compSize := file.Size
+				if file.ShouldCompress {
+					compSize = file.CompressedSize
+				}
+				if entry.Name != file.Name || entry.Directory != dir.Name ||
+					entry.NameOffset != file.NameOffset || entry.DirOffset != dir.NameOffset ||
+					entry.DataOffset != file.DataOffset || entry.UncompSize != file.Size ||
+					entry.CompSize != compSize || entry.IsCompressed != file.ShouldCompress ||
+					entry.Flags != file.Flag || entry.Alignment != file.EntryAlignment {
+					t.Errorf("pad %t: entry %d = %+v, written from %+v in %s", pad, idx-1, entry, file, dir.Name)
+				}
+			}
+		}
+		if !pad && entries[0].Alignment != 1 {
+			t.Errorf("unpadded entry declares alignment %d, want 1", entries[0].Alignment)
+		}
+		if entries[2].DataOffset <= 1<<32 {
+			t.Errorf("pad %t: last entry's offset 0x%X doesn't pass 4 GB", pad, entries[2].DataOffset)
+		}
+		problems, err := verify.Check(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, problem := range problems {
+			t.Errorf("pad %t: %s", pad, problem)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package pack
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const defaultLevel = 9
+
+// Built-in rules, matching what pack has always done. Copy this into a
+// file and pass it with --policy to change it.
+const defaultPolicy = `{
+	"rules": [
+		{
+			"extensions": [
+				".bk2", ".bik", ".str2_pc", ".strh_pc", ".cvbm_pc", ".gvbm_pc",
+				".vpp_pc", ".vpkg", ".ttf", ".xml", ".ridv_pc", ".lua",
+				".wem_pad", ".hkcmp_64m", ".refl_xbox3_gdk", ".refl_pc",
+				".sr2_h", ".bin", ".txt", ".vint_proj"
+			],
+			"compress": false
+		},
+		{
+			"extensions": [".fxo_dx11_pc", ".fxo_dx12_pc", ".fxo_vk_pc"],
+			"alignment": 16
+		},
+		{
+			"extensions": [".bk2"],
+			"alignment": 2048
+		},
+		{
+			"outputs": [".str2_pc"],
+			"compress": true
+		}
+	]
+}`
+
+func parsePolicy(data []byte) (*Policy, error) {
+	var policy Policy
+	err := json.Unmarshal(data, &policy)
+	if err != nil {
+		return nil, err
+	}
+	for idx, rule := range policy.Rules {
+		if rule.Alignment != nil && *rule.Alignment == 0 {
+			return nil, errors.New("Policy rule " + strconv.Itoa(idx) + " has an alignment of 0.")
+		}
+		if rule.Level != nil && !(*rule.Level >= 1 && *rule.Level <= 12) {
+			return nil, errors.New("Policy rule " + strconv.Itoa(idx) + " has a level outside 1-12.")
+		}
+		for _, glob := range rule.Globs {
+			_, err := path.Match(glob, "")
+			if err != nil {
+				return nil, errors.New("Policy rule " + strconv.Itoa(idx) + " has a bad glob: " + glob)
+			}
+		}
+	}
+	return &policy, nil
+}
+
+// Loads the policy file at policyPath, or the built-in one if it's
+// empty. outPath picks the output rules; noCompression overrides every
+// compress setting.
+func LoadPolicy(policyPath, outPath string, noCompression bool) (*Policy, error) {
+	data := []byte(defaultPolicy)
+	if policyPath != "" {
+		var err error
+		data, err = os.ReadFile(policyPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	policy, err := parsePolicy(data)
+	if err != nil {
+		return nil, err
+	}
+	policy.outPath = strings.ToLower(outPath)
+	policy.noCompression = noCompression
+	return policy, nil
+}
+
+func matchesAny(values []string, match func(string) bool) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if match(strings.ToLower(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// A rule applies when every matcher it sets matches. dir and fname are
+// lower case, dir with forward slashes.
+func (rule *Rule) matches(outPath, dir, fname string) bool {
+	fullPath := path.Join(dir, fname)
+	return matchesAny(rule.Extensions, func(ext string) bool {
+		return strings.HasSuffix(fname, ext)
+	}) && matchesAny(rule.Globs, func(glob string) bool {
+		nameOk, _ := path.Match(glob, fname)
+		pathOk, _ := path.Match(glob, fullPath)
+		return nameOk || pathOk
+	}) && matchesAny(rule.Dirs, func(ruleDir string) bool {
+		ruleDir = strings.Trim(strings.ReplaceAll(ruleDir, `\`, "/"), "/")
+		return dir == ruleDir || strings.HasPrefix(dir, ruleDir+"/")
+	}) && matchesAny(rule.Outputs, func(ext string) bool {
+		return strings.HasSuffix(outPath, ext)
+	})
+}
+
+// Builds a file entry for fname in the in-pack directory dir. Matching
+// rules are applied in order, later ones overriding earlier ones.
+func (policy *Policy) NewFile(dir, fname, fullPath string, size uint64) *File {
+	var (
+		shouldCompress = true
+		align          = uint16(1)
+		level          = defaultLevel
+		flag           *uint16
+	)
+	matchDir := strings.Trim(strings.ToLower(strings.ReplaceAll(dir, `\`, "/")), "/")
+	matchName := strings.ToLower(fname)
+	for _, rule := range policy.Rules {
+		if !rule.matches(policy.outPath, matchDir, matchName) {
+			continue
+		}
+		if rule.Compress != nil {
+			shouldCompress = *rule.Compress
+		}
+		if rule.Alignment != nil {
+			align = *rule.Alignment
+		}
+		if rule.Level != nil {
+			level = *rule.Level
+		}
+		if rule.Flags != nil {
+			flag = rule.Flags
+		}
+	}
+	if policy.noCompression {
+		shouldCompress = false
+	}
+	file := &File{
+		Name:           fname,
+		Size:           size,
+		FullPath:       fullPath,
+		ShouldCompress: shouldCompress,
+		Alignment:      align,
+		Level:          level,
+	}
+	if flag != nil {
+		file.Flag = *flag
+	} else if shouldCompress {
+		file.Flag = 1
+	}
+	return file
+}
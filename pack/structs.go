@@ -1,20 +1,37 @@
 package pack
 
+import "main/unpack"
+
 type File struct {
 	Name           string
-	Size           int64
-	NameOffset     int64
-	DataOffset     int64
+	Size           uint64
+	NameOffset     uint64
+	DataOffset     uint64
 	FullPath       string
 	CompressedPath string
-	CompressedSize int64
+	CompressedSize uint64
+	// Set when CompressedPath is in the cache and must be kept.
+	Cached bool
+	// Set when the stored bytes are copied from an existing packfile.
+	SourcePath     string
+	SourceOffset   int64
 	ShouldCompress bool
-	Flag           int16
-	Alignment      int16
+	Flag           uint16
+	Alignment      uint16
+	// Alignment the entry declares, set by layout: Alignment when
+	// padding, 1 otherwise since the data isn't aligned then.
+	EntryAlignment uint16
+	Level          int
+	// Content hash, set when deduplicating or caching.
+	Hash string
+	// Earlier file with the same content and settings, whose data
+	// this one shares.
+	DuplicateOf *File
+	SharesData  bool
 }
 
 type Dir struct {
-	NameOffset int64
+	NameOffset uint64
 	Name       string
 	Files      []*File
 }
@@ -22,4 +39,49 @@ type Dir struct {
 type Dirs struct {
 	FileTotal int
 	Dirs      []*Dir
+	// Original packfile and its header checksum. What the checksum is
+	// computed over isn't known, so 0 is written unless the output
+	// comes out byte for byte the same as Source.
+	Source   string
+	Checksum uint32
+	index    map[string]*Dir
+}
+
+type ExtStats struct {
+	Files      int
+	Compressed int
+	Size       uint64
+	Stored     uint64
+}
+
+// One policy rule. Empty matchers match everything; unset settings
+// leave the previous value alone.
+type Rule struct {
+	Extensions []string `json:"extensions,omitempty"`
+	Globs      []string `json:"globs,omitempty"`
+	Dirs       []string `json:"dirs,omitempty"`
+	Outputs    []string `json:"outputs,omitempty"`
+	Compress   *bool    `json:"compress,omitempty"`
+	Alignment  *uint16  `json:"alignment,omitempty"`
+	Flags      *uint16  `json:"flags,omitempty"`
+	Level      *int     `json:"level,omitempty"`
+}
+
+// An input folder and the in-pack directory prefix for files under it.
+type Root struct {
+	Folder string
+	Prefix string
+}
+
+type Mapping struct {
+	Roots []*Root
+	paths map[string]*unpack.ManifestEntry
+	// Manifest directories by their lower cased GameDir form.
+	dirs map[string]string
+}
+
+type Policy struct {
+	Rules         []*Rule `json:"rules"`
+	outPath       string
+	noCompression bool
 }
@@ -0,0 +1,396 @@
+package patch
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"main/pack"
+	"main/unpack"
+	"main/utils"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultOutPath      = "SRTools_patched.vpp_pc"
+	defaultBuildOutPath = "SRTools_built.vpp_pc"
+)
+
+func isPackfile(path string) bool {
+	return strings.HasSuffix(path, ".vpp_pc") || strings.HasSuffix(path, ".str2_pc")
+}
+
+func processArgs(args *utils.Args, build bool) (*utils.Args, error) {
+	if build && len(args.InPaths) < 2 {
+		return nil, errors.New("Build needs the base packfile and at least one overlay folder.")
+	} else if !build && len(args.InPaths) != 2 {
+		return nil, errors.New("Patch needs two input paths: the original packfile and the folder of changed files.")
+	}
+	if !isPackfile(args.InPaths[0]) {
+		return nil, errors.New("Invalid input file file extension.")
+	}
+	if args.OutPath == "" {
+		if build {
+			args.OutPath = defaultBuildOutPath
+		} else {
+			args.OutPath = defaultOutPath
+		}
+	}
+	if !isPackfile(args.OutPath) {
+		return nil, errors.New("Invalid output file file extension.")
+	}
+	if !(args.Threads >= 1 && args.Threads <= 50) {
+		return nil, errors.New("Max threads must be between 1 and 50.")
+	}
+	if !(args.MinSaving >= 0 && args.MinSaving <= 100) {
+		return nil, errors.New("Minimum saving must be between 0 and 100.")
+	}
+	inPath, err := filepath.Abs(args.InPaths[0])
+	if err != nil {
+		return nil, err
+	}
+	outPath, err := filepath.Abs(args.OutPath)
+	if err != nil {
+		return nil, err
+	}
+	if inPath == outPath {
+		return nil, errors.New("Output path can't be the original packfile.")
+	}
+	return args, nil
+}
+
+// Case and separator insensitive key for an in-pack path.
+func inPackKey(dir, name string) string {
+	key := path.Join(strings.ReplaceAll(dir, `\`, "/"), name)
+	return strings.ToLower(key)
+}
+
+// Reads the delete list and maps each line, a path relative to the
+// input folder, to its in-pack key.
+func readDeleteList(listPath string, mapping *pack.Mapping) (map[string]string, error) {
+	deletes := map[string]string{}
+	if listPath == "" {
+		return deletes, nil
+	}
+	f, err := os.Open(listPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		rel := path.Clean(strings.ReplaceAll(line, `\`, "/"))
+		dir, name, ok := mapping.InPack(rel)
+		if !ok {
+			fmt.Println("Outside the mapped roots, can't delete:", line)
+			continue
+		}
+		deletes[inPackKey(dir, name)] = line
+	}
+	return deletes, scanner.Err()
+}
+
+// Maps each file in folder to its in-pack path, keyed by inPackKey.
+// Returns the keys in walk order and any files nothing maps.
+func readChanges(folder string, mapping *pack.Mapping) (map[string]*Change, []string, []string, error) {
+	var (
+		order   []string
+		skipped []string
+	)
+	changes := map[string]*Change{}
+	err := filepath.Walk(folder, func(fullPath string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(folder, fullPath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		dir, name, ok := mapping.InPack(rel)
+		if !ok {
+			skipped = append(skipped, rel)
+			return nil
+		}
+		key := inPackKey(dir, name)
+		changes[key] = &Change{
+			Path:      fullPath,
+			Size:      uint64(f.Size()),
+			Directory: dir,
+			Name:      name,
+		}
+		order = append(order, key)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return changes, order, skipped, nil
+}
+
+func loadMapping(args *utils.Args) (*pack.Mapping, error) {
+	var (
+		manifest *unpack.Manifest
+		err      error
+	)
+	if args.Manifest != "" {
+		manifest, err = unpack.ReadManifest(args.Manifest)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pack.NewMapping(args.Roots, manifest)
+}
+
+func printSkipped(skipped []string) {
+	if len(skipped) == 0 {
+		return
+	}
+	fmt.Printf("Skipped %d file(s) outside the mapped roots:\n", len(skipped))
+	for _, rel := range skipped {
+		fmt.Println(rel)
+	}
+}
+
+// Extracts an entry's data from the original packfile into tempPath,
+// decompressed, so it can go through pack's policy again.
+func extractEntry(f *os.File, header *unpack.Header, entry *unpack.FileEntry, tempPath string, idx int) (string, error) {
+	outPath := filepath.Join(tempPath, "base", strconv.Itoa(idx))
+	err := os.MkdirAll(filepath.Dir(outPath), 0755)
+	if err != nil {
+		return "", err
+	}
+	out, err := os.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	src := io.NewSectionReader(f, int64(header.BaseOffset+entry.DataOffset), int64(entry.CompSize))
+	_, err = io.Copy(out, src)
+	out.Close()
+	if err != nil {
+		return "", err
+	}
+	if entry.IsCompressed {
+		err = unpack.Decompress(outPath)
+	}
+	return outPath, err
+}
+
+// Writes a new packfile from the original at args.InPaths[0] with
+// changes replacing or adding entries and deletes removed. Untouched
+// entries are copied through unless recompress is set.
+func rebuild(args *utils.Args, changes map[string]*Change, order []string, deletes map[string]string, recompress bool) error {
+	inPath := args.InPaths[0]
+	policy, err := pack.LoadPolicy(args.Policy, args.OutPath, args.NoCompression)
+	if err != nil {
+		return err
+	}
+	tempPath, err := utils.MkdirTemp()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempPath)
+	f, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fmt.Println("Parsing original packfile...")
+	header, entries, err := unpack.Parse(f)
+	if err != nil {
+		return err
+	}
+	var (
+		replaced int
+		copied   int
+		deleted  int
+		added    int
+	)
+	dirs := &pack.Dirs{
+		Dirs:     []*pack.Dir{},
+		Source:   inPath,
+		Checksum: header.Checksum,
+	}
+	dirNames := map[string]string{}
+	used := map[string]bool{}
+	// Copied entries by the data they copy and how it's stored, so
+	// entries that shared data in the original still share it.
+	copies := map[string]*pack.File{}
+	if recompress {
+		fmt.Println("Extracting untouched entries to recompress...")
+	}
+	for idx, entry := range entries {
+		key := inPackKey(entry.Directory, entry.Name)
+		dirNames[inPackKey(entry.Directory, "")] = entry.Directory
+		if _, ok := deletes[key]; ok {
+			used[key] = true
+			deleted++
+			continue
+		}
+		var file *pack.File
+		if change, ok := changes[key]; ok {
+			file = policy.NewFile(entry.Directory, entry.Name, change.Path, change.Size)
+			file.Alignment = entry.Alignment
+			used[key] = true
+			replaced++
+		} else if recompress {
+			extracted, err := extractEntry(f, header, entry, tempPath, idx)
+			if err != nil {
+				return err
+			}
+			file = policy.NewFile(entry.Directory, entry.Name, extracted, entry.UncompSize)
+			file.Alignment = entry.Alignment
+			copied++
+		} else {
+			file = &pack.File{
+				Name:           entry.Name,
+				Size:           entry.UncompSize,
+				CompressedSize: entry.CompSize,
+				ShouldCompress: entry.IsCompressed,
+				Flag:           entry.Flags,
+				Alignment:      entry.Alignment,
+				SourcePath:     inPath,
+				SourceOffset:   int64(header.BaseOffset + entry.DataOffset),
+			}
+			key := fmt.Sprintf("%d/%d/%t/%d/%d",
+				entry.DataOffset, entry.CompSize, entry.IsCompressed, entry.Flags, entry.Alignment)
+			if orig, ok := copies[key]; ok {
+				file.DuplicateOf = orig
+			} else {
+				copies[key] = file
+			}
+			copied++
+		}
+		pack.Add(dirs, entry.Directory, file)
+	}
+	for _, key := range order {
+		if used[key] {
+			continue
+		}
+		change := changes[key]
+		dir, ok := dirNames[inPackKey(change.Directory, "")]
+		if !ok {
+			dir = change.Directory
+		}
+		file := policy.NewFile(dir, change.Name, change.Path, change.Size)
+		pack.Add(dirs, dir, file)
+		added++
+	}
+	for key, line := range deletes {
+		if !used[key] {
+			fmt.Println("Not in packfile, can't delete:", line)
+		}
+	}
+	for _, dir := range dirs.Dirs {
+		dirs.FileTotal += len(dir.Files)
+	}
+	untouched := "copied through"
+	if recompress {
+		untouched = "recompressed"
+	}
+	fmt.Printf(
+		"%d replaced, %d added, %d deleted, %d %s.\n",
+		replaced, added, deleted, copied, untouched)
+	err = pack.Compress(dirs, tempPath, args)
+	if err != nil {
+		return err
+	}
+	err = pack.Write(dirs, args)
+	if err != nil {
+		return err
+	}
+	pack.PrintRatios(dirs)
+	return nil
+}
+
+func Run(args *utils.Args) error {
+	args, err := processArgs(args, false)
+	if err != nil {
+		return err
+	}
+	mapping, err := loadMapping(args)
+	if err != nil {
+		return err
+	}
+	deletes, err := readDeleteList(args.Delete, mapping)
+	if err != nil {
+		return err
+	}
+	changes, order, skipped, err := readChanges(args.InPaths[1], mapping)
+	if err != nil {
+		return err
+	}
+	printSkipped(skipped)
+	return rebuild(args, changes, order, deletes, false)
+}
+
+// Builds a packfile from a base packfile and overlay folders. Later
+// overlays override earlier ones; paths touched by more than one are
+// reported.
+func Build(args *utils.Args) error {
+	args, err := processArgs(args, true)
+	if err != nil {
+		return err
+	}
+	mapping, err := loadMapping(args)
+	if err != nil {
+		return err
+	}
+	deletes, err := readDeleteList(args.Delete, mapping)
+	if err != nil {
+		return err
+	}
+	var order []string
+	changes := map[string]*Change{}
+	owners := map[string][]string{}
+	for _, overlay := range args.InPaths[1:] {
+		fmt.Println("Reading overlay " + overlay + "...")
+		overlayChanges, overlayOrder, skipped, err := readChanges(overlay, mapping)
+		if err != nil {
+			return err
+		}
+		printSkipped(skipped)
+		for _, key := range overlayOrder {
+			if _, ok := changes[key]; !ok {
+				order = append(order, key)
+			}
+			changes[key] = overlayChanges[key]
+			// The same overlay given twice, or two of its files mapping to
+			// the same path, isn't a conflict.
+			if last := len(owners[key]) - 1; last >= 0 && owners[key][last] == overlay {
+				continue
+			}
+			owners[key] = append(owners[key], overlay)
+		}
+	}
+	var conflicts int
+	for _, key := range order {
+		if len(owners[key]) < 2 {
+			continue
+		}
+		if conflicts == 0 {
+			fmt.Println("Conflicts:")
+		}
+		conflicts++
+		overlays := owners[key]
+		change := changes[key]
+		fmt.Printf(
+			"%s\\%s: %s (using %s)\n", change.Directory, change.Name,
+			strings.Join(overlays, ", "), overlays[len(overlays)-1])
+	}
+	if conflicts > 0 {
+		fmt.Printf("%d path(s) touched by more than one overlay.\n", conflicts)
+	}
+	return rebuild(args, changes, order, deletes, args.Recompress)
+}
@@ -0,0 +1,9 @@
+package patch
+
+// A file from the changes folder and where it goes in the packfile.
+type Change struct {
+	Path      string
+	Size      uint64
+	Directory string
+	Name      string
+}
@@ -1,19 +1,48 @@
 package unpack
 
 type Header struct {
-	DirEntryCount int32
-	DirCount      int32
-	NamesOffset   int32
-	BaseOffset    int32
+	Checksum      uint32
+	DirEntryCount uint32
+	DirCount      uint32
+	NamesOffset   uint64
+	NamesSize     uint32
+	PackSize      uint64
+	DataSize      uint64
+	CompDataSize  uint64
+	Timestamp     uint64
+	BaseOffset    uint64
 }
 
 type FileEntry struct {
-	NameOffset   int64
-	DirOffset    int64
-	DataOffset   int64
-	CompSize     int64
-	UncompSize   int64
+	NameOffset   uint64
+	DirOffset    uint64
+	DataOffset   uint64
+	CompSize     uint64
+	UncompSize   uint64
 	IsCompressed bool
+	Flags        uint16
+	Alignment    uint16
 	Name         string
 	Directory    string
 }
+
+// Entry order and settings of an extracted packfile, written beside
+// the extracted files so pack can rebuild it the same way.
+type Manifest struct {
+	Packfile   string           `json:"packfile"`
+	Checksum   uint32           `json:"checksum"`
+	BaseOffset uint64           `json:"base_offset"`
+	Entries    []*ManifestEntry `json:"entries"`
+}
+
+type ManifestEntry struct {
+	Directory  string `json:"directory"`
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	Compressed bool   `json:"compressed"`
+	Flags      uint16 `json:"flags"`
+	Alignment  uint16 `json:"alignment"`
+	// Data offset from the data base, for reference; pack works out
+	// its own.
+	Offset uint64 `json:"offset"`
+}
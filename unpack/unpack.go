@@ -2,16 +2,17 @@ package unpack
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"main/utils"
 	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -100,7 +101,11 @@ func parseHeader(f *os.File) (*Header, error) {
 	if version != 17 {
 		return nil, errors.New("Unsupported packfile version.")
 	}
-	_, err = f.Seek(8, io.SeekCurrent)
+	checksum, err := utils.ReadUint32(f)
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Seek(4, io.SeekCurrent)
 	if err != nil {
 		return nil, err
 	}
@@ -116,18 +121,40 @@ func parseHeader(f *os.File) (*Header, error) {
 	if err != nil {
 		return nil, err
 	}
-	_, err = f.Seek(36, io.SeekCurrent)
+	namesSize, err := utils.ReadUint32(f)
+	if err != nil {
+		return nil, err
+	}
+	packSize, err := utils.ReadUint64(f)
 	if err != nil {
 		return nil, err
 	}
-	baseOffset, err := utils.ReadUint32(f)
+	dataSize, err := utils.ReadUint64(f)
+	if err != nil {
+		return nil, err
+	}
+	compDataSize, err := utils.ReadUint64(f)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := utils.ReadUint64(f)
+	if err != nil {
+		return nil, err
+	}
+	baseOffset, err := utils.ReadUint64(f)
 	if err != nil {
 		return nil, err
 	}
 	header := &Header{
+		Checksum:      checksum,
 		DirEntryCount: dirEntryCount,
 		DirCount:      dirCount,
-		NamesOffset:   dirEntriesOffset + namesOffset,
+		NamesOffset:   dirEntriesOffset + uint64(namesOffset),
+		NamesSize:     namesSize,
+		PackSize:      packSize,
+		DataSize:      dataSize,
+		CompDataSize:  compDataSize,
+		Timestamp:     timestamp,
 		BaseOffset:    baseOffset,
 	}
 	return header, nil
@@ -161,25 +188,19 @@ func parseEntries(f *os.File, header *Header) ([]*FileEntry, error) {
 		if err != nil {
 			return nil, err
 		}
-		// comp, err := readFlag(f)
-		// if err != nil {
-		// 	return nil, err
-		// }
-		// cond, err := readFlag(f)
-		// if err != nil {
-		// 	return nil, err
-		// }
-
-		// Causes EOFs.
-		// flags, err := utils.ReadUint32(f)
-		// if err != nil {
-		// 	return nil, err
-		// }
-		isComp := uint64(compSize) != math.MaxUint64
+		flags, err := utils.ReadUint16(f)
+		if err != nil {
+			return nil, err
+		}
+		alignment, err := utils.ReadUint16(f)
+		if err != nil {
+			return nil, err
+		}
+		isComp := compSize != math.MaxUint64
 		if !isComp {
 			compSize = uncompSize
 		}
-		_, err = f.Seek(8, io.SeekCurrent)
+		_, err = f.Seek(4, io.SeekCurrent)
 		if err != nil {
 			return nil, err
 		}
@@ -190,6 +211,8 @@ func parseEntries(f *os.File, header *Header) ([]*FileEntry, error) {
 			UncompSize:   uncompSize,
 			CompSize:     compSize,
 			IsCompressed: isComp,
+			Flags:        flags,
+			Alignment:    alignment,
 		}
 		entries = append(entries, entry)
 	}
@@ -216,16 +239,15 @@ func readString(f *os.File, offset int64) (string, error) {
 	return value, nil
 }
 
-func parseNamesAndDirs(f *os.File, entries []*FileEntry, namesOffset int32) error {
+func parseNamesAndDirs(f *os.File, entries []*FileEntry, namesOffset uint64) error {
 	for _, entry := range entries {
-		namesOffset := int64(namesOffset)
-		offset := namesOffset + int64(entry.NameOffset)
+		offset := int64(namesOffset + entry.NameOffset)
 		name, err := readString(f, offset)
 		if err != nil {
 			return err
 		}
 		entry.Name = name
-		offset = namesOffset + int64(entry.DirOffset)
+		offset = int64(namesOffset + entry.DirOffset)
 		dir, err := readString(f, offset)
 		if err != nil {
 			return err
@@ -247,15 +269,18 @@ func parseNamesAndDirs(f *os.File, entries []*FileEntry, namesOffset int32) erro
 // 	return buf, err
 // }
 
-func decompress(outPath string) error {
+// Decompresses the lz4 file at outPath in place.
+func Decompress(outPath string) error {
 	decOutPath := outPath + "_dec"
 	var (
 		errBuffer bytes.Buffer
 		args      = []string{"-d", outPath, decOutPath, "--rm", "-f"}
 	)
-	cmd := exec.Command("lz4", args...)
+	utils.AddCleanup(decOutPath)
+	defer utils.RemoveCleanup(decOutPath)
+	cmd := utils.Command("lz4", args...)
 	cmd.Stderr = &errBuffer
-	err := cmd.Run()
+	err := utils.RunCommand(cmd)
 	if err != nil {
 		errString := err.Error() + "\n" + errBuffer.String()
 		return errors.New(errString)
@@ -264,61 +289,184 @@ func decompress(outPath string) error {
 	return err
 }
 
+// Decompresses lz4 data from r into w.
+func DecompressStream(r io.Reader, w io.Writer) error {
+	var errBuffer bytes.Buffer
+	cmd := utils.Command("lz4", "-d", "-c")
+	cmd.Stdin = r
+	cmd.Stdout = w
+	cmd.Stderr = &errBuffer
+	err := utils.RunCommand(cmd)
+	if err != nil {
+		errString := err.Error() + "\n" + errBuffer.String()
+		return errors.New(errString)
+	}
+	return nil
+}
+
 func writeFile(buf []byte, outPath string, isComp bool) error {
-	outFile, err := os.OpenFile(outPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	outFile, err := utils.CreateAtomic(outPath)
 	if err != nil {
 		return err
 	}
+	defer outFile.Abort()
 	_, err = outFile.Write(buf)
-	outFile.Close()
 	if err != nil {
 		return err
 	}
 	if isComp {
-		err = decompress(outPath)
+		err = outFile.Close()
+		if err != nil {
+			return err
+		}
+		err = Decompress(outFile.Name())
+		if err != nil {
+			return err
+		}
 	}
-	return err
+	return outFile.Commit()
+}
+
+// Converts an in-pack directory, which uses backslashes, to the local
+// OS's separators.
+func LocalDir(dir string) string {
+	return filepath.FromSlash(strings.ReplaceAll(dir, `\`, "/"))
 }
 
-func writeFiles(f *os.File, entries []*FileEntry, _outPath string, baseOffset int32, threads int) error {
-	var wg sync.WaitGroup
+// Extracts entries in up to threads goroutines. Stops starting new ones
+// on the first error or an interrupt, and returns once those running
+// have finished.
+func writeFiles(f *os.File, entries []*FileEntry, _outPath string, baseOffset uint64, threads int) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	setErr := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+	}
+	ctx := utils.Context()
 	ch := make(chan struct{}, threads)
 	for _, entry := range entries {
 		ch <- struct{}{}
-		outPath := filepath.Join(_outPath, entry.Directory)
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed || ctx.Err() != nil {
+			break
+		}
+		outPath := filepath.Join(_outPath, LocalDir(entry.Directory))
 		err := makeDirs(outPath)
 		if err != nil {
-			panic(err)
+			setErr(err)
+			break
 		}
 		name := entry.Name
 		isComp := entry.IsCompressed
 		fullOutPath := filepath.Join(outPath, name)
 		uncompSize := entry.UncompSize
-		dataOffset := int64(baseOffset) + int64(entry.DataOffset)
-		fmt.Println(filepath.Join(entry.Directory, name))
+		dataOffset := int64(baseOffset + entry.DataOffset)
+		fmt.Println(filepath.Join(LocalDir(entry.Directory), name))
 		fmt.Println("Start offset:", fmt.Sprintf("0x%X", dataOffset))
 		fmt.Println("End offset:", fmt.Sprintf("0x%X", dataOffset+int64(uncompSize)))
 		fmt.Println("Compressed size:", entry.CompSize, "bytes")
 		fmt.Println("Uncompressed size:", uncompSize, "bytes")
 		fmt.Println("Compressed:", isComp)
+		fmt.Println("Alignment:", entry.Alignment)
 		fmt.Println("")
 		wg.Add(1)
 		go func(entry *FileEntry) {
 			defer wg.Done()
+			defer func() { <-ch }()
 			buf := make([]byte, entry.CompSize)
-			_, err = f.ReadAt(buf, dataOffset)
-			if err != nil {
-				panic(err)
+			_, err := f.ReadAt(buf, dataOffset)
+			if err == nil {
+				err = writeFile(buf, fullOutPath, isComp)
 			}
-			err = writeFile(buf, fullOutPath, isComp)
 			if err != nil {
-				panic(err)
+				setErr(err)
 			}
-			<-ch
 		}(entry)
 	}
 	wg.Wait()
-	return nil
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// Manifest file name for an extracted packfile.
+func ManifestName(packPath string) string {
+	return filepath.Base(packPath) + ".manifest.json"
+}
+
+func writeManifest(header *Header, entries []*FileEntry, packPath, outPath string) error {
+	manifest := &Manifest{
+		Packfile:   filepath.Base(packPath),
+		Checksum:   header.Checksum,
+		BaseOffset: header.BaseOffset,
+		Entries:    []*ManifestEntry{},
+	}
+	root := filepath.Dir(outPath)
+	for _, entry := range entries {
+		rel, err := filepath.Rel(root, filepath.Join(outPath, LocalDir(entry.Directory), entry.Name))
+		if err != nil {
+			return err
+		}
+		manifest.Entries = append(manifest.Entries, &ManifestEntry{
+			Directory:  entry.Directory,
+			Name:       entry.Name,
+			Path:       filepath.ToSlash(rel),
+			Compressed: entry.IsCompressed,
+			Flags:      entry.Flags,
+			Alignment:  entry.Alignment,
+			Offset:     entry.DataOffset,
+		})
+	}
+	m, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filepath.Join(root, ManifestName(packPath)), m)
+}
+
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// Parses a packfile's header, entries and names without extracting,
+// reading from the start of f.
+func Parse(f *os.File) (*Header, []*FileEntry, error) {
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, nil, err
+	}
+	header, err := parseHeader(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, err := parseEntries(f, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = parseNamesAndDirs(f, entries, header.NamesOffset)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, entries, nil
 }
 
 func Run(args *utils.Args) error {
@@ -342,6 +490,10 @@ func Run(args *utils.Args) error {
 		if err != nil {
 			return err
 		}
+		if header.Timestamp != 0 {
+			fmt.Println("Timestamp:", time.Unix(int64(header.Timestamp), 0).UTC().Format(time.RFC3339))
+		}
+		fmt.Printf("Checksum: 0x%08X.\n", header.Checksum)
 		fmt.Println("Parsing entries...")
 		entries, err := parseEntries(f, header)
 		if err != nil {
@@ -352,6 +504,10 @@ func Run(args *utils.Args) error {
 		if err != nil {
 			return err
 		}
+		err = writeManifest(header, entries, path, outPath)
+		if err != nil {
+			return err
+		}
 		err = writeFiles(
 			f, entries, outPath, header.BaseOffset, args.Threads)
 		if err != nil {
@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+var (
+	ctx, cancel = context.WithCancel(context.Background())
+	cleanupMu   sync.Mutex
+	commands    sync.WaitGroup
+	// Temp files and folders to remove if the run is interrupted, with
+	// the open file to close first for files written through one.
+	cleanupPaths = map[string]*os.File{}
+)
+
+// Cancelled when the run is interrupted.
+func Context() context.Context {
+	return ctx
+}
+
+// Exits if the run was interrupted. Called once the command has
+// returned, so nothing is still writing: waits for running commands to
+// be killed, then removes temp files and partial outputs.
+func ExitIfInterrupted() {
+	if ctx.Err() == nil {
+		return
+	}
+	commands.Wait()
+	Cleanup()
+	os.Exit(1)
+}
+
+// Makes a command that's killed if the run is interrupted. Run it with
+// RunCommand.
+func Command(name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, name, args...)
+}
+
+// Runs cmd, tracked so an interrupt waits for it to be killed before
+// cleaning up.
+func RunCommand(cmd *exec.Cmd) error {
+	commands.Add(1)
+	defer commands.Done()
+	return cmd.Run()
+}
+
+func AddCleanup(path string) {
+	cleanupMu.Lock()
+	if _, ok := cleanupPaths[path]; !ok {
+		cleanupPaths[path] = nil
+	}
+	cleanupMu.Unlock()
+}
+
+// Registers f's path for cleanup, closing f before it's removed.
+func addCleanupFile(f *os.File) {
+	cleanupMu.Lock()
+	cleanupPaths[f.Name()] = f
+	cleanupMu.Unlock()
+}
+
+func RemoveCleanup(path string) {
+	cleanupMu.Lock()
+	delete(cleanupPaths, path)
+	cleanupMu.Unlock()
+}
+
+// Removes everything registered with AddCleanup, closing open files
+// first so they can be removed on Windows.
+func Cleanup() {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	for path, f := range cleanupPaths {
+		if f != nil {
+			f.Close()
+		}
+		os.RemoveAll(path)
+		delete(cleanupPaths, path)
+	}
+}
+
+// On SIGINT or SIGTERM, cancels Context so the running command stops
+// and returns, leaving the exit to ExitIfInterrupted. A second signal
+// cleans up and exits straight away.
+func HandleSignals() {
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ch
+		fmt.Println("\nInterrupted, cleaning up...")
+		cancel()
+		<-ch
+		fmt.Println("\nInterrupted again, exiting...")
+		Cleanup()
+		os.Exit(1)
+	}()
+}
+
+// Makes a temp folder that's removed if the run is interrupted. The
+// caller removes it otherwise.
+func MkdirTemp() (string, error) {
+	path, err := os.MkdirTemp(os.TempDir(), "")
+	if err != nil {
+		return "", err
+	}
+	AddCleanup(path)
+	return path, nil
+}
+
+// A temp file beside its destination. Commit renames it over the
+// destination, so a failed or interrupted write never leaves a partial
+// output or touches an existing file.
+type AtomicFile struct {
+	*os.File
+	path      string
+	committed bool
+}
+
+func CreateAtomic(path string) (*AtomicFile, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	addCleanupFile(f)
+	err = f.Chmod(0755)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		RemoveCleanup(f.Name())
+		return nil, err
+	}
+	return &AtomicFile{File: f, path: path}, nil
+}
+
+// Closes the temp file, if it isn't already, and renames it over the
+// destination.
+func (f *AtomicFile) Commit() error {
+	err := f.File.Close()
+	if err != nil && !errors.Is(err, os.ErrClosed) {
+		return err
+	}
+	err = os.Rename(f.Name(), f.path)
+	if err != nil {
+		return err
+	}
+	f.committed = true
+	RemoveCleanup(f.Name())
+	return nil
+}
+
+// Removes the temp file unless it was committed. Safe to defer.
+func (f *AtomicFile) Abort() {
+	if f.committed {
+		return
+	}
+	f.File.Close()
+	os.Remove(f.Name())
+	RemoveCleanup(f.Name())
+}
+
+// Writes data to path through an AtomicFile.
+func WriteFileAtomic(path string, data []byte) error {
+	f, err := CreateAtomic(path)
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+	_, err = f.Write(data)
+	if err != nil {
+		return err
+	}
+	return f.Commit()
+}
@@ -2,8 +2,23 @@ package utils
 
 type Args struct {
 	Command       string   `arg:"positional, required"`
-	InPaths       []string `arg:"-i, required" help:"Input path(s)."`
+	InPaths       []string `arg:"-i" help:"Input path(s)."`
 	OutPath       string   `arg:"-o" help:"Output path. Path will be made if it doesn't already exist."`
-	Threads       int      `arg:"-t" default:"10" help:"Max threads (1-50). Be careful; memory intensive."`
+	Threads       int      `arg:"-t" default:"10" help:"Max threads (1-50) for unpacking and pack compression. Be careful; memory intensive."`
 	NoCompression bool     `arg:"-n" help:"Don't compress any files when packing. Might be a bit more stable."`
+	Order         string   `arg:"--order" help:"Entry order for pack: manifest, lexical, extension or size. Defaults to manifest with --manifest, otherwise lexical."`
+	Manifest      string   `arg:"--manifest" help:"Manifest written by unpack, to pack entries in their original order."`
+	Roots         []string `arg:"--root,separate" help:"Input folder to in-pack directory mapping as folder=prefix, repeatable. Defaults to sr5= and ctg=..\\ctg\\."`
+	Policy        string   `arg:"--policy" help:"JSON file of compression and alignment rules for pack and patch. Defaults to the built-in rules."`
+	Recompress    bool     `arg:"--recompress" help:"Recompress untouched entries when building instead of copying them through."`
+	Delete        string   `arg:"--delete" help:"Text file of paths to remove when patching, one per line, relative to the input folder (e.g. sr5\\data\\foo.lua)."`
+	MinSaving     int      `arg:"--minsaving" help:"Minimum saving in percent to keep a file compressed; files that save less are stored uncompressed (0-100)."`
+	Dedupe        bool     `arg:"--dedupe" help:"Store identical files once when packing, with their entries sharing the data."`
+	Cache         bool     `arg:"--cache" help:"Reuse compressed output from earlier runs, cached by file content and compression settings."`
+	CacheDir      string   `arg:"--cachedir" help:"Folder for --cache and prunecache. Defaults to SRTools\\lz4 in the user cache folder."`
+	MaxAge        int      `arg:"--maxage" help:"With prunecache, only remove cached files unused for this many days. 0 removes all of them."`
+	NoVerify      bool     `arg:"--noverify" help:"Don't check the written packfile against its sources before keeping it."`
+	Pad           bool     `arg:"--pad" help:"Pad packed data to each entry's alignment and start it on a 2048 byte boundary. Off by default; the rules aren't confirmed against stock packfiles."`
+	Stock         string   `arg:"--stock" help:"Stock packfile for verify to compare the data size fields and data base against."`
+	Timestamp     *int64   `arg:"--timestamp" help:"Packfile timestamp (Unix seconds) for reproducible builds. Defaults to the current time."`
 }
@@ -8,48 +8,87 @@ import (
 	"os"
 )
 
+// Rounds value up to a multiple of align.
+func AlignUp(value, align uint64) uint64 {
+	if align <= 1 {
+		return value
+	}
+	return (value + align - 1) / align * align
+}
+
 func GetCurrentPos(f *os.File) (int64, error) {
 	return f.Seek(0, io.SeekCurrent)
 }
 
-func ReadUint32(f *os.File) (int32, error) {
+func ReadUint16(r io.Reader) (uint16, error) {
+	buf := make([]byte, 2)
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(buf), nil
+}
+
+func ReadUint32(r io.Reader) (uint32, error) {
 	buf := make([]byte, 4)
-	_, err := f.Read(buf)
+	_, err := io.ReadFull(r, buf)
 	if err != nil {
 		return 0, err
 	}
-	value := binary.LittleEndian.Uint32(buf)
-	return int32(value), nil
+	return binary.LittleEndian.Uint32(buf), nil
 }
 
-func ReadUint64(f *os.File) (int64, error) {
+func ReadUint64(r io.Reader) (uint64, error) {
 	buf := make([]byte, 8)
-	_, err := f.Read(buf)
+	_, err := io.ReadFull(r, buf)
 	if err != nil {
 		return 0, err
 	}
-	value := binary.LittleEndian.Uint64(buf)
-	return int64(value), nil
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+// Signed variant for formats that store int32 fields, like scribe.
+func ReadInt32(r io.Reader) (int32, error) {
+	value, err := ReadUint32(r)
+	return int32(value), err
 }
 
-func WriteUint32(f *os.File, value int32) error {
+func WriteUint16(w io.Writer, value uint16) error {
+	buf := make([]byte, 2)
+	binary.LittleEndian.PutUint16(buf, value)
+	_, err := w.Write(buf)
+	return err
+}
+
+func WriteUint32(w io.Writer, value uint32) error {
 	buf := make([]byte, 4)
-	binary.LittleEndian.PutUint32(buf, uint32(value))
-	_, err := f.Write(buf)
+	binary.LittleEndian.PutUint32(buf, value)
+	_, err := w.Write(buf)
 	return err
 }
 
-func ReadBytes(f *os.File, bytesLen int64) ([]byte, error) {
+func WriteUint64(w io.Writer, value uint64) error {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, value)
+	_, err := w.Write(buf)
+	return err
+}
+
+func WriteInt32(w io.Writer, value int32) error {
+	return WriteUint32(w, uint32(value))
+}
+
+func ReadBytes(r io.Reader, bytesLen int64) ([]byte, error) {
 	buf := make([]byte, bytesLen)
-	_, err := f.Read(buf)
+	_, err := io.ReadFull(r, buf)
 	if err != nil {
 		return nil, err
 	}
 	return buf, nil
 }
 
-func WriteNull(f *os.File, rep int) error {
-	_, err := f.Write(bytes.Repeat([]byte{0x0}, rep))
+func WriteNull(w io.Writer, rep int) error {
+	_, err := w.Write(bytes.Repeat([]byte{0x0}, rep))
 	return err
 }
 
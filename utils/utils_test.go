@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Past what a uint32 can hold.
+const over4GB = 4<<30 + 12345
+
+func TestUintRoundTrip(t *testing.T) {
+	values64 := []uint64{0, 1, math.MaxUint32, math.MaxUint32 + 1, over4GB, math.MaxUint64}
+	var buf bytes.Buffer
+	for _, value := range values64 {
+		err := WriteUint64(&buf, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range values64 {
+		got, err := ReadUint64(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("ReadUint64 = %d, want %d", got, want)
+		}
+	}
+	values32 := []uint32{0, 1, math.MaxInt32 + 1, math.MaxUint32}
+	for _, value := range values32 {
+		err := WriteUint32(&buf, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range values32 {
+		got, err := ReadUint32(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("ReadUint32 = %d, want %d", got, want)
+		}
+	}
+	err := WriteUint16(&buf, math.MaxUint16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got16, err := ReadUint16(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got16 != math.MaxUint16 {
+		t.Errorf("ReadUint16 = %d, want %d", got16, math.MaxUint16)
+	}
+	err = WriteInt32(&buf, -2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotInt, err := ReadInt32(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotInt != -2 {
+		t.Errorf("ReadInt32 = %d, want -2", gotInt)
+	}
+}
+
+func TestReadShort(t *testing.T) {
+	_, err := ReadUint64(bytes.NewReader([]byte{1, 2, 3}))
+	if err == nil {
+		t.Error("ReadUint64 of 3 bytes didn't fail")
+	}
+	_, err = ReadBytes(bytes.NewReader([]byte{1, 2, 3}), 4)
+	if err == nil {
+		t.Error("ReadBytes past the end didn't fail")
+	}
+}
+
+// Writes and reads 64-bit values beyond 4 GB in a sparse file, where
+// 32-bit offsets would wrap.
+func TestUint64SparseFile(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "sparse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	size := int64(over4GB + 4096)
+	err = f.Truncate(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := []int64{math.MaxUint32 - 3, math.MaxUint32 + 1, over4GB}
+	for _, offset := range offsets {
+		_, err = f.Seek(offset, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = WriteUint64(f, uint64(offset)<<8|0xFF)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, offset := range offsets {
+		_, err = f.Seek(offset, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadUint64(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := uint64(offset)<<8 | 0xFF; got != want {
+			t.Errorf("at 0x%X: got 0x%X, want 0x%X", offset, got, want)
+		}
+	}
+	pos, err := GetCurrentPos(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != over4GB+8 {
+		t.Errorf("GetCurrentPos = 0x%X, want 0x%X", pos, over4GB+8)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != size {
+		t.Errorf("size = %d, want %d", stat.Size(), size)
+	}
+}
+
+func TestAlignUp(t *testing.T) {
+	tests := []struct {
+		value, align, want uint64
+	}{
+		{0, 2048, 0},
+		{1, 2048, 2048},
+		{2048, 2048, 2048},
+		{5, 0, 5},
+		{5, 1, 5},
+		{over4GB, 16, (over4GB + 15) / 16 * 16},
+		{math.MaxUint32 + 1, 2048, math.MaxUint32 + 1},
+	}
+	for _, test := range tests {
+		got := AlignUp(test.value, test.align)
+		if got != test.want {
+			t.Errorf("AlignUp(%d, %d) = %d, want %d", test.value, test.align, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package verify
+
+// Entries of one extension and declared alignment, and how many of
+// their data offsets are multiples of it.
+type AlignStats struct {
+	Ext       string
+	Alignment uint16
+	Entries   int
+	FromBase  int
+	FromStart int
+}
+
+// A header size field, the values it could be defined as, and the
+// names of those it equals.
+type SizeField struct {
+	Name       string
+	Value      uint64
+	Candidates []SizeCandidate
+	Matches    []string
+}
+
+type SizeCandidate struct {
+	Name  string
+	Value uint64
+}
@@ -0,0 +1,353 @@
+package verify
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"main/unpack"
+	"main/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	dirEntriesOffset = 0x78
+	dirEntrySize     = 48
+	dataAlign        = 2048
+)
+
+func processArgs(args *utils.Args) (*utils.Args, error) {
+	for _, path := range args.InPaths {
+		if !(strings.HasSuffix(path, ".vpp_pc") || strings.HasSuffix(path, ".str2_pc")) {
+			return nil, errors.New("Invalid input file file extension.")
+		}
+	}
+	return args, nil
+}
+
+func readDirOffsets(f *os.File, header *unpack.Header) ([]uint64, error) {
+	offset := int64(dirEntriesOffset + uint64(header.DirEntryCount)*dirEntrySize)
+	_, err := f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	var dirOffsets []uint64
+	for i := uint32(0); i < header.DirCount; i++ {
+		dirOffset, err := utils.ReadUint64(f)
+		if err != nil {
+			return nil, err
+		}
+		dirOffsets = append(dirOffsets, dirOffset)
+	}
+	return dirOffsets, nil
+}
+
+// Checks that a packfile's layout holds together: pack size, names
+// table, entries inside the file and not overlapping. The data size
+// fields and the data base aren't checked here, as their definitions
+// aren't confirmed; see SizeReport and CompareSizes. Returns a
+// description of each problem found.
+func Check(f *os.File) ([]string, error) {
+	var problems []string
+	report := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	fileSize := uint64(stat.Size())
+	header, entries, err := unpack.Parse(f)
+	if err != nil {
+		return nil, err
+	}
+	dirOffsets, err := readDirOffsets(f, header)
+	if err != nil {
+		return nil, err
+	}
+	if header.PackSize != fileSize {
+		report("Pack size is %d, file is %d bytes.", header.PackSize, fileSize)
+	}
+	namesStart := dirEntriesOffset + uint64(header.DirEntryCount)*dirEntrySize +
+		uint64(header.DirCount)*8
+	if header.NamesOffset != namesStart {
+		report("Names offset is 0x%X, expected 0x%X after the directory table.",
+			header.NamesOffset, namesStart)
+	}
+	namesEnd := header.NamesOffset + uint64(header.NamesSize)
+	if header.BaseOffset < namesEnd {
+		report("Data offset base 0x%X is inside the names table (ends 0x%X).",
+			header.BaseOffset, namesEnd)
+	}
+	for idx, dirOffset := range dirOffsets {
+		if dirOffset >= uint64(header.NamesSize) {
+			report("Directory %d name offset %d is outside the names table.", idx, dirOffset)
+		}
+	}
+	for idx, entry := range entries {
+		if entry.NameOffset >= uint64(header.NamesSize) || entry.DirOffset >= uint64(header.NamesSize) {
+			report("Entry %d (%s) name or directory offset is outside the names table.",
+				idx, entry.Name)
+		}
+		end := header.BaseOffset + entry.DataOffset + entry.CompSize
+		if end > fileSize {
+			report("Entry %d (%s) data ends at 0x%X, past the end of the file.",
+				idx, entry.Name, end)
+		}
+	}
+	var prev *unpack.FileEntry
+	for _, entry := range byOffset(entries) {
+		// Deduplicated entries share the exact same range.
+		if prev != nil && entry.DataOffset == prev.DataOffset && entry.CompSize == prev.CompSize {
+			if entry.UncompSize != prev.UncompSize || entry.IsCompressed != prev.IsCompressed {
+				report("Entries %s and %s share data but disagree on its size.", prev.Name, entry.Name)
+			}
+			continue
+		}
+		if prev != nil && entry.DataOffset < prev.DataOffset+prev.CompSize {
+			report("Entries %s and %s have overlapping data.", prev.Name, entry.Name)
+		}
+		prev = entry
+	}
+	return problems, nil
+}
+
+// Entries sorted by data offset. Entries sharing a data range end up
+// next to each other; callers skip or compare them as they need.
+func byOffset(entries []*unpack.FileEntry) []*unpack.FileEntry {
+	sorted := make([]*unpack.FileEntry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].DataOffset < sorted[j].DataOffset
+	})
+	return sorted
+}
+
+// Works out which of the candidate definitions a packfile's data size,
+// compressed data size and data base follow. Run on stock packfiles,
+// it shows the definitions the game's own tools use.
+func SizeReport(header *unpack.Header, entries []*unpack.FileEntry, fileSize uint64) []*SizeField {
+	var (
+		uncomp        uint64
+		uncompAligned uint64
+		stored        uint64
+		storedAligned uint64
+		prev          *unpack.FileEntry
+	)
+	for _, entry := range byOffset(entries) {
+		// Shared data counts once.
+		if prev != nil && entry.DataOffset == prev.DataOffset && entry.CompSize == prev.CompSize {
+			continue
+		}
+		align := uint64(entry.Alignment)
+		uncomp += entry.UncompSize
+		uncompAligned = utils.AlignUp(uncompAligned, align) + entry.UncompSize
+		stored += entry.CompSize
+		storedAligned = utils.AlignUp(storedAligned, align) + entry.CompSize
+		prev = entry
+	}
+	namesEnd := header.NamesOffset + uint64(header.NamesSize)
+	var dataBlock uint64
+	if header.BaseOffset <= fileSize {
+		dataBlock = fileSize - header.BaseOffset
+	}
+	fields := []*SizeField{
+		{Name: "Data size", Value: header.DataSize, Candidates: []SizeCandidate{
+			{"uncompressed sizes", uncomp},
+			{"uncompressed sizes with alignment padding", uncompAligned},
+		}},
+		{Name: "Compressed data size", Value: header.CompDataSize, Candidates: []SizeCandidate{
+			{"stored sizes", stored},
+			{"stored sizes with alignment padding", storedAligned},
+			{"data block", dataBlock},
+		}},
+		{Name: "Data offset base", Value: header.BaseOffset, Candidates: []SizeCandidate{
+			{"end of names table", namesEnd},
+			{fmt.Sprintf("end of names table aligned to %d", dataAlign), utils.AlignUp(namesEnd, dataAlign)},
+		}},
+	}
+	for _, field := range fields {
+		for _, candidate := range field.Candidates {
+			if candidate.Value == field.Value {
+				field.Matches = append(field.Matches, candidate.Name)
+			}
+		}
+	}
+	return fields
+}
+
+// Checks a packfile's size fields follow the same definitions as a stock
+// packfile's. A field passes if it matches any definition the stock
+// field matches; fields the stock packfile matches none of are skipped.
+func CompareSizes(fields []*SizeField, stock []*SizeField) []string {
+	var problems []string
+	for idx, field := range fields {
+		want := stock[idx].Matches
+		if len(want) == 0 {
+			continue
+		}
+		var ok bool
+		for _, name := range want {
+			for _, match := range field.Matches {
+				if match == name {
+					ok = true
+				}
+			}
+		}
+		if !ok {
+			got := "none of the candidates"
+			if len(field.Matches) > 0 {
+				got = strings.Join(field.Matches, ", ")
+			}
+			problems = append(problems, fmt.Sprintf(
+				"%s is %d, matching %s; the stock packfile's matches %s.",
+				field.Name, field.Value, got, strings.Join(want, ", ")))
+		}
+	}
+	return problems
+}
+
+func readSizes(f *os.File) ([]*SizeField, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	header, entries, err := unpack.Parse(f)
+	if err != nil {
+		return nil, err
+	}
+	return SizeReport(header, entries, uint64(stat.Size())), nil
+}
+
+func printSizes(fields []*SizeField) {
+	fmt.Println("Size fields:")
+	for _, field := range fields {
+		matches := "none of the candidates"
+		if len(field.Matches) > 0 {
+			matches = strings.Join(field.Matches, ", ")
+		}
+		fmt.Printf("%s %d matches %s.\n", field.Name, field.Value, matches)
+		for _, candidate := range field.Candidates {
+			fmt.Printf("  %s: %d\n", candidate.Name, candidate.Value)
+		}
+	}
+}
+
+// Describes how entry data lines up with each declared alignment, per
+// file extension, as evidence for the alignment rules: run on stock
+// packfiles, it shows whether offsets honour the alignment field and
+// whether they count from the data base or the start of the file.
+func AlignmentReport(header *unpack.Header, entries []*unpack.FileEntry) []string {
+	stats := map[string]*AlignStats{}
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name))
+		if ext == "" {
+			ext = "(none)"
+		}
+		key := fmt.Sprintf("%s %d", ext, entry.Alignment)
+		stat, ok := stats[key]
+		if !ok {
+			stat = &AlignStats{Ext: ext, Alignment: entry.Alignment}
+			stats[key] = stat
+		}
+		align := uint64(entry.Alignment)
+		if align == 0 {
+			align = 1
+		}
+		stat.Entries++
+		if entry.DataOffset%align == 0 {
+			stat.FromBase++
+		}
+		if (header.BaseOffset+entry.DataOffset)%align == 0 {
+			stat.FromStart++
+		}
+	}
+	var keys []string
+	for key := range stats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	namesEnd := header.NamesOffset + uint64(header.NamesSize)
+	gap := fmt.Sprintf("%d byte(s) after the names table", header.BaseOffset-namesEnd)
+	if header.BaseOffset < namesEnd {
+		gap = fmt.Sprintf("%d byte(s) inside the names table", namesEnd-header.BaseOffset)
+	}
+	lines := []string{fmt.Sprintf("Data offset base 0x%X, %s, %d mod %d.",
+		header.BaseOffset, gap, header.BaseOffset%dataAlign, dataAlign)}
+	for _, key := range keys {
+		stat := stats[key]
+		lines = append(lines, fmt.Sprintf(
+			"%s, alignment %d: %d entries, %d aligned from the data base, %d from the start of the file.",
+			stat.Ext, stat.Alignment, stat.Entries, stat.FromBase, stat.FromStart))
+	}
+	return lines
+}
+
+func printAlignment(f *os.File) error {
+	header, entries, err := unpack.Parse(f)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Alignment:")
+	for _, line := range AlignmentReport(header, entries) {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func Run(args *utils.Args) error {
+	args, err := processArgs(args)
+	if err != nil {
+		return err
+	}
+	var stock []*SizeField
+	if args.Stock != "" {
+		f, err := os.Open(args.Stock)
+		if err != nil {
+			return err
+		}
+		stock, err = readSizes(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	var failed bool
+	for _, path := range args.InPaths {
+		fmt.Println("Verifying " + path + "...")
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		problems, err := Check(f)
+		var fields []*SizeField
+		if err == nil {
+			fields, err = readSizes(f)
+		}
+		if err == nil {
+			err = printAlignment(f)
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+		printSizes(fields)
+		if stock != nil {
+			problems = append(problems, CompareSizes(fields, stock)...)
+		}
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) == 0 {
+			fmt.Println("OK.")
+		} else {
+			failed = true
+		}
+	}
+	if failed {
+		return errors.New("Packfile failed verification.")
+	}
+	return nil
+}